@@ -0,0 +1,304 @@
+package web
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 绑定的数据来源, 同时也是标签的名字
+const (
+	bindSourcePath   = "path"
+	bindSourceQuery  = "query"
+	bindSourceHeader = "header"
+	bindSourceForm   = "form"
+)
+
+var bindSources = []string{bindSourcePath, bindSourceQuery, bindSourceHeader, bindSourceForm}
+
+var (
+	errBindPointerOnly = errors.New("web: 只支持指向结构体的一级指针")
+
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// BindError 绑定某个字段失败
+type BindError struct {
+	// 结构体里面的字段名
+	Field string
+	// 数据来源, 例如 path, query, header, form, body
+	Source string
+	// 标签里面的 key
+	Key string
+	Err error
+}
+
+func (e *BindError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("web: 绑定字段 %s 失败, 来源 %s: %v", e.Field, e.Source, e.Err)
+	}
+	return fmt.Sprintf("web: 绑定字段 %s 失败, 来源 %s[%s]: %v", e.Field, e.Source, e.Key, e.Err)
+}
+
+// publicMessage 返回给客户端的信息
+// 不包含 Err, 例如 strconv 的错误信息, 也不包含结构体的字段名
+func (e *BindError) publicMessage() string {
+	if e.Key == "" {
+		return fmt.Sprintf("参数格式错误, 来源 %s", e.Source)
+	}
+	return fmt.Sprintf("参数格式错误, 来源 %s[%s]", e.Source, e.Key)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// Bind 把请求里面的数据填充到结构体里面
+// 先按照 Content-Type 找注册的 Codec 把 body 反序列化到 val 里面, 例如 JSON 用 json 标签, XML 用 xml 标签
+// 再按照标签从路径参数、查询参数、Header 和表单里面读取, 会覆盖 body 里面的值
+//
+//	type Req struct {
+//		ID    int64    `path:"id"`
+//		Page  int      `query:"page"`
+//		Tags  []string `query:"tag"`
+//		Token string   `header:"X-Token"`
+//		Name  string   `form:"name"`
+//		Email string   `json:"email"`
+//	}
+//
+// 请求里面没有的 key 会被跳过, 保留原来的值
+// 重复的 key, 例如 ?tag=a&tag=b, 可以绑定到切片上
+func (c *Context) Bind(val any) error {
+	typ := reflect.TypeOf(val)
+	if typ == nil || typ.Kind() != reflect.Pointer || typ.Elem().Kind() != reflect.Struct {
+		return errBindPointerOnly
+	}
+	m, err := defaultBindRegistry.get(typ)
+	if err != nil {
+		return err
+	}
+
+	if err = c.bindBody(val); err != nil {
+		return &BindError{Field: typ.Elem().Name(), Source: "body", Err: err}
+	}
+
+	structVal := reflect.ValueOf(val).Elem()
+	for _, fd := range m.fields {
+		vals, err := c.bindValues(fd.source, fd.key)
+		if err != nil {
+			return &BindError{Field: fd.name, Source: fd.source, Key: fd.key, Err: err}
+		}
+		if len(vals) == 0 {
+			continue
+		}
+		if err = setBindValue(structVal.FieldByIndex(fd.index), vals); err != nil {
+			return &BindError{Field: fd.name, Source: fd.source, Key: fd.key, Err: err}
+		}
+	}
+	return nil
+}
+
+// bindBody 按照 Content-Type 找 Codec 反序列化 body
+// 没有对应 Codec 的, 例如表单, 交给 form 标签处理
+func (c *Context) bindBody(val any) error {
+	if c.Req.Body == nil || c.Req.ContentLength == 0 {
+		return nil
+	}
+	codec, ok := c.codecRegistry().get(c.Req.Header.Get("Content-Type"))
+	if !ok {
+		return nil
+	}
+	data, err := c.RawBody()
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(data, val)
+}
+
+func (c *Context) bindValues(source string, key string) ([]string, error) {
+	switch source {
+	case bindSourcePath:
+		val, ok := c.PathParams[key]
+		if !ok {
+			return nil, nil
+		}
+		return []string{val}, nil
+	case bindSourceQuery:
+		if c.queryValues == nil {
+			c.queryValues = c.Req.URL.Query()
+		}
+		return c.queryValues[key], nil
+	case bindSourceHeader:
+		return c.Req.Header.Values(key), nil
+	case bindSourceForm:
+		if err := c.parseForm(); err != nil {
+			return nil, err
+		}
+		return c.Req.Form[key], nil
+	}
+	return nil, nil
+}
+
+// bindModel 结构体绑定用的元数据
+type bindModel struct {
+	fields []*bindField
+}
+
+type bindField struct {
+	// 字段名
+	name string
+	// 字段的下标, 嵌入结构体的字段会有多个下标
+	index  []int
+	source string
+	key    string
+}
+
+// bindRegistry 和 orm 里面的 registry 一样, 缓存每个类型解析出来的元数据
+type bindRegistry struct {
+	models sync.Map
+}
+
+var defaultBindRegistry = &bindRegistry{}
+
+func (r *bindRegistry) get(typ reflect.Type) (*bindModel, error) {
+	m, ok := r.models.Load(typ)
+	if ok {
+		return m.(*bindModel), nil
+	}
+	// 并发的时候可能会重复解析, 但是结果都是一样的
+	res, err := r.parse(typ.Elem(), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.models.Store(typ, res)
+	return res, nil
+}
+
+func (r *bindRegistry) parse(typ reflect.Type, parentIndex []int) (*bindModel, error) {
+	res := &bindModel{}
+	for i := 0; i < typ.NumField(); i++ {
+		fd := typ.Field(i)
+		index := append(append(make([]int, 0, len(parentIndex)+1), parentIndex...), i)
+		// 嵌入的结构体, 把里面的字段展开
+		if fd.Anonymous && fd.Type.Kind() == reflect.Struct {
+			sub, err := r.parse(fd.Type, index)
+			if err != nil {
+				return nil, err
+			}
+			res.fields = append(res.fields, sub.fields...)
+			continue
+		}
+		if !fd.IsExported() {
+			continue
+		}
+		for _, source := range bindSources {
+			key, ok := fd.Tag.Lookup(source)
+			if !ok || key == "" || key == "-" {
+				continue
+			}
+			if !canBind(fd.Type) {
+				return nil, fmt.Errorf("web: 字段 %s 的类型 %s 不支持绑定", fd.Name, fd.Type)
+			}
+			res.fields = append(res.fields, &bindField{
+				name:   fd.Name,
+				index:  index,
+				source: source,
+				key:    key,
+			})
+		}
+	}
+	return res, nil
+}
+
+func canBind(typ reflect.Type) bool {
+	if reflect.PointerTo(typ).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		return canBind(typ.Elem())
+	case reflect.Slice:
+		return typ.Elem().Kind() != reflect.Slice && canBind(typ.Elem())
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// setBindValue 切片用全部的值, 其它类型用第一个值
+func setBindValue(fd reflect.Value, vals []string) error {
+	if fd.Kind() == reflect.Slice && !reflect.PointerTo(fd.Type()).Implements(textUnmarshalerType) {
+		res := reflect.MakeSlice(fd.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if err := setBindString(res.Index(i), val); err != nil {
+				return err
+			}
+		}
+		fd.Set(res)
+		return nil
+	}
+	return setBindString(fd, vals[0])
+}
+
+func setBindString(fd reflect.Value, val string) error {
+	if fd.Kind() == reflect.Pointer {
+		if fd.IsNil() {
+			fd.Set(reflect.New(fd.Type().Elem()))
+		}
+		return setBindString(fd.Elem(), val)
+	}
+
+	// 例如 time.Time, net.IP
+	if u, ok := fd.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(val))
+	}
+
+	if fd.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		fd.SetInt(int64(d))
+		return nil
+	}
+
+	switch fd.Kind() {
+	case reflect.String:
+		fd.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		fd.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(val, 10, fd.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fd.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(val, 10, fd.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fd.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(val, fd.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fd.SetFloat(f)
+	default:
+		return fmt.Errorf("web: 不支持的类型 %s", fd.Type())
+	}
+	return nil
+}
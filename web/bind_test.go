@@ -0,0 +1,159 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindPage struct {
+	Page int  `query:"page"`
+	Size *int `query:"size"`
+}
+
+type bindUserReq struct {
+	bindPage
+	ID       int64         `path:"id"`
+	Tags     []string      `query:"tag"`
+	Token    string        `header:"X-Token"`
+	Name     string        `form:"name" json:"name"`
+	Email    string        `json:"email"`
+	Timeout  time.Duration `query:"timeout"`
+	Birthday time.Time     `query:"birthday"`
+	Active   bool          `query:"active"`
+	ignored  string        `query:"ignored"`
+}
+
+func TestContext_Bind(t *testing.T) {
+	size := 20
+	testCases := []struct {
+		name        string
+		req         func() *http.Request
+		pathParams  map[string]string
+		val         any
+		wantVal     any
+		wantErr     error
+		wantErrText string
+	}{
+		{
+			name: "all source",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost,
+					"/user/12?page=2&size=20&tag=a&tag=b&timeout=1s&birthday=2000-01-02T00:00:00Z&active=true&ignored=abc",
+					strings.NewReader("name=zhangsan"))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				req.Header.Set("X-Token", "token")
+				return req
+			},
+			pathParams: map[string]string{"id": "12"},
+			val:        &bindUserReq{},
+			wantVal: &bindUserReq{
+				bindPage: bindPage{Page: 2, Size: &size},
+				ID:       12,
+				Tags:     []string{"a", "b"},
+				Token:    "token",
+				Name:     "zhangsan",
+				Timeout:  time.Second,
+				Birthday: time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+				Active:   true,
+			},
+		},
+		{
+			// body 先反序列化, 表单里面的值会覆盖 body 里面的
+			name: "json body",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/user/12?page=3",
+					strings.NewReader(`{"name":"lisi","email":"lisi@example.com"}`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			pathParams: map[string]string{"id": "12"},
+			val:        &bindUserReq{},
+			wantVal: &bindUserReq{
+				bindPage: bindPage{Page: 3},
+				ID:       12,
+				Name:     "lisi",
+				Email:    "lisi@example.com",
+			},
+		},
+		{
+			name: "invalid int",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/abc", nil)
+			},
+			pathParams:  map[string]string{"id": "abc"},
+			val:         &bindUserReq{},
+			wantErrText: `web: 绑定字段 ID 失败, 来源 path[id]: strconv.ParseInt: parsing "abc": invalid syntax`,
+		},
+		{
+			name: "invalid json",
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{"name":`))
+				req.Header.Set("Content-Type", "application/json")
+				return req
+			},
+			val:         &bindUserReq{},
+			wantErrText: "web: 绑定字段 bindUserReq 失败, 来源 body: unexpected end of JSON input",
+		},
+		{
+			name: "not pointer",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user", nil)
+			},
+			val:     bindUserReq{},
+			wantErr: errBindPointerOnly,
+		},
+		{
+			name: "unsupported type",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user", nil)
+			},
+			val: &struct {
+				M map[string]string `query:"m"`
+			}{},
+			wantErrText: "web: 字段 M 的类型 map[string]string 不支持绑定",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := &Context{
+				Req:        tc.req(),
+				PathParams: tc.pathParams,
+			}
+			err := ctx.Bind(tc.val)
+			if tc.wantErr != nil {
+				assert.Equal(t, tc.wantErr, err)
+				return
+			}
+			if tc.wantErrText != "" {
+				assert.EqualError(t, err, tc.wantErrText)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantVal, tc.val)
+		})
+	}
+}
+
+func TestContext_BindError(t *testing.T) {
+	ctx := &Context{
+		Req: httptest.NewRequest(http.MethodGet, "/user?page=abc", nil),
+	}
+	err := ctx.Bind(&bindUserReq{})
+	var bindErr *BindError
+	require.True(t, errors.As(err, &bindErr))
+	assert.Equal(t, "Page", bindErr.Field)
+	assert.Equal(t, "query", bindErr.Source)
+	assert.Equal(t, "page", bindErr.Key)
+
+	// 元数据被缓存起来了
+	_, ok := defaultBindRegistry.models.Load(reflect.TypeOf(&bindUserReq{}))
+	assert.True(t, ok)
+}
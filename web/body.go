@@ -0,0 +1,126 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ServerWithMaxBodySize 限制请求 body 的大小, 超过了返回 413
+// 单个路由可以用 MaxBodySize 覆盖, 小于等于 0 代表不限制
+func ServerWithMaxBodySize(size int64) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.maxBodySize = size
+	}
+}
+
+// MaxBodySize 作为路由上的 middleware 使用, 覆盖 ServerWithMaxBodySize 的设置
+// 例如上传文件的路由需要更大的限制
+//
+//	h.Post("/upload", uploader.Handle(), MaxBodySize(100<<20))
+//
+// Content-Length 已经超过限制的请求直接返回 413, 不会执行后面的逻辑
+func MaxBodySize(size int64) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			if size > 0 && ctx.Req.ContentLength > size {
+				ctx.respTooLarge()
+				return
+			}
+			ctx.maxBodySize = size
+			// body 已经被缓存了, 例如前面的签名校验 middleware 调用了 RawBody
+			if ctx.rawBody != nil {
+				if size > 0 && int64(len(ctx.rawBody)) > size {
+					ctx.respTooLarge()
+					return
+				}
+				next(ctx)
+				return
+			}
+			ctx.Req.Body = limitBody(ctx.writer.ResponseWriter, ctx.body, size)
+			next(ctx)
+		}
+	}
+}
+
+// checkBodySize 在 handler 之前检查 Content-Length
+// 不然 handler 不读 body 的话, 超过 ServerWithMaxBodySize 的请求也会正常返回
+// 放在路由上的 middleware 后面, 这样 MaxBodySize 能够覆盖服务器的限制
+func checkBodySize(next HandleFunc) HandleFunc {
+	return func(ctx *Context) {
+		if ctx.maxBodySize > 0 && ctx.Req.ContentLength > ctx.maxBodySize {
+			ctx.respTooLarge()
+			return
+		}
+		next(ctx)
+	}
+}
+
+// w 要用 net/http 原始的 ResponseWriter, 不能是 responseWriter 包装过的
+// 超过大小的时候 MaxBytesReader 会通知它在响应之后关闭连接, 不再读剩下的 body
+func limitBody(w http.ResponseWriter, body io.ReadCloser, size int64) io.ReadCloser {
+	if size <= 0 || body == nil || body == http.NoBody {
+		return body
+	}
+	return http.MaxBytesReader(w, body, size)
+}
+
+// RawBody 读取整个 body 并且缓存下来, 后面再调用就直接返回缓存的数据
+// 读完之后 Req.Body 会被替换成缓存数据的 reader, 所以 BindJSON, 表单解析
+// 或者直接读 Req.Body 的代码依旧能拿到完整的 body, 例如:
+//
+//	// 签名校验的 middleware
+//	body, err := ctx.RawBody()
+//	if err != nil {
+//		return
+//	}
+//	if !verify(ctx.Req.Header.Get("X-Signature"), body) {
+//		ctx.RespStatusCode = http.StatusUnauthorized
+//		return
+//	}
+//	next(ctx)
+//
+// 超过了大小限制的时候, 会设置 413 响应并且返回 *http.MaxBytesError
+func (c *Context) RawBody() ([]byte, error) {
+	if c.rawBody == nil {
+		if c.Req.Body == nil || c.Req.Body == http.NoBody {
+			c.rawBody = []byte{}
+			return c.rawBody, nil
+		}
+		data, err := io.ReadAll(c.Req.Body)
+		if err != nil {
+			return nil, c.bodyErr(err)
+		}
+		c.rawBody = data
+	}
+	c.resetBody()
+	return c.rawBody, nil
+}
+
+// resetBody 缓存了 body 的话, 让 Req.Body 重新从头开始读
+func (c *Context) resetBody() {
+	if c.rawBody != nil {
+		c.Req.Body = io.NopCloser(bytes.NewReader(c.rawBody))
+	}
+}
+
+// parseForm 表单解析也会读 body, 所以先把缓存的 body 放回去
+func (c *Context) parseForm() error {
+	c.resetBody()
+	return c.bodyErr(c.Req.ParseForm())
+}
+
+// bodyErr 读 body 超过大小限制的时候设置 413 响应
+func (c *Context) bodyErr(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.respTooLarge()
+	}
+	return err
+}
+
+func (c *Context) respTooLarge() {
+	c.RespStatusCode = http.StatusRequestEntityTooLarge
+	c.RespData = []byte("REQUEST ENTITY TOO LARGE")
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContext_RawBody(t *testing.T) {
+	// 模拟签名校验的 middleware, 先读一遍 body
+	var signed string
+	sign := func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			body, err := ctx.RawBody()
+			require.NoError(t, err)
+			signed = string(body)
+			next(ctx)
+		}
+	}
+	server := NewHTTPServer(ServerWithMiddleware(sign))
+	server.Post("/json", func(ctx *Context) {
+		val := map[string]string{}
+		require.NoError(t, ctx.BindJSON(&val))
+		// 直接读 Req.Body 也能拿到完整的数据
+		data, err := io.ReadAll(ctx.Req.Body)
+		require.NoError(t, err)
+		body, err := ctx.RawBody()
+		require.NoError(t, err)
+		assert.Equal(t, body, data)
+		ctx.RespData = []byte(val["name"])
+	})
+	server.Post("/form", func(ctx *Context) {
+		name, err := ctx.FormValue("name")
+		require.NoError(t, err)
+		ctx.RespData = []byte(name)
+	})
+
+	testCases := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantBody    string
+	}{
+		{
+			name:        "json",
+			path:        "/json",
+			contentType: "application/json",
+			body:        `{"name":"Tom"}`,
+			wantBody:    "Tom",
+		},
+		{
+			name:        "form",
+			path:        "/form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "name=Jerry",
+			wantBody:    "Jerry",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+			assert.Equal(t, tc.body, signed)
+		})
+	}
+}
+
+func TestHTTPServer_MaxBodySize(t *testing.T) {
+	server := NewHTTPServer(ServerWithMaxBodySize(8))
+	var handled bool
+	handler := func(ctx *Context) {
+		handled = true
+		val := map[string]string{}
+		if err := ctx.BindJSON(&val); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			assert.True(t, errors.As(err, &maxBytesErr))
+			return
+		}
+		ctx.RespData = []byte(val["name"])
+	}
+	server.Post("/default", handler)
+	server.Post("/large", handler, MaxBodySize(64))
+	server.Post("/small", handler, MaxBodySize(4))
+	server.Post("/unlimited", handler, MaxBodySize(0))
+	server.Post("/ignore", func(ctx *Context) {
+		handled = true
+	})
+
+	testCases := []struct {
+		name string
+		path string
+		body string
+		// 不知道长度的 body, 只能在读的时候发现超过了限制
+		unknownLength bool
+		wantCode      int
+		wantBody      string
+		wantHandled   bool
+	}{
+		{
+			name:        "within server limit",
+			path:        "/default",
+			body:        `{"a":""}`,
+			wantCode:    http.StatusOK,
+			wantHandled: true,
+		},
+		{
+			name:          "exceed server limit",
+			path:          "/default",
+			body:          `{"name":"Tom"}`,
+			unknownLength: true,
+			wantCode:      http.StatusRequestEntityTooLarge,
+			wantBody:      "REQUEST ENTITY TOO LARGE",
+			wantHandled:   true,
+		},
+		{
+			// handler 不读 body 也要返回 413
+			name:     "exceed server limit without reading",
+			path:     "/ignore",
+			body:     `{"name":"Tom"}`,
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: "REQUEST ENTITY TOO LARGE",
+		},
+		{
+			name:        "route larger limit",
+			path:        "/large",
+			body:        `{"name":"Tom"}`,
+			wantCode:    http.StatusOK,
+			wantBody:    "Tom",
+			wantHandled: true,
+		},
+		{
+			// Content-Length 已经超过了, 不会执行 handler
+			name:     "route smaller limit",
+			path:     "/small",
+			body:     `{"a":""}`,
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantBody: "REQUEST ENTITY TOO LARGE",
+		},
+		{
+			name:          "unlimited",
+			path:          "/unlimited",
+			body:          `{"name":"Jerry"}`,
+			unknownLength: true,
+			wantCode:      http.StatusOK,
+			wantBody:      "Jerry",
+			wantHandled:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handled = false
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			if tc.unknownLength {
+				req.ContentLength = -1
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+			assert.Equal(t, tc.wantHandled, handled)
+		})
+	}
+}
+
+func TestContext_BindAndValidateTooLarge(t *testing.T) {
+	server := NewHTTPServer(ServerWithMaxBodySize(4))
+	server.Post("/user", func(ctx *Context) {
+		val := &codecUser{}
+		assert.Error(t, ctx.BindAndValidate(val))
+	})
+	req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(`{"name":"Tom"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+}
+
+func TestHTTPServer_MaxBodySizeCloseConn(t *testing.T) {
+	server := NewHTTPServer(ServerWithMaxBodySize(8))
+	handler := func(ctx *Context) {
+		if _, err := ctx.RawBody(); err != nil {
+			return
+		}
+		ctx.RespData = []byte("ok")
+	}
+	server.Post("/default", handler)
+	server.Post("/small", handler, MaxBodySize(4))
+	httpSrv := httptest.NewServer(server)
+	defer httpSrv.Close()
+
+	for _, path := range []string{"/default", "/small"} {
+		t.Run(path, func(t *testing.T) {
+			// 不知道长度, 使用 chunked 编码, 只有读的时候才知道超过了
+			body := io.MultiReader(strings.NewReader(strings.Repeat("a", 16)))
+			resp, err := http.Post(httpSrv.URL+path, "text/plain", body)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+			// net/http 拿到了原始的 ResponseWriter, 会关闭连接
+			assert.True(t, resp.Close)
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Codec 负责某一种 Content-Type 的序列化和反序列化
+// 响应的时候按照 Accept 选择 Codec, 绑定请求的时候按照 Content-Type 选择
+type Codec interface {
+	// ContentType 例如 application/json, 不带参数
+	ContentType() string
+	Marshal(val any) ([]byte, error)
+	Unmarshal(data []byte, val any) error
+}
+
+// ServerWithCodecs 注册 Codec, 相同 ContentType 的会覆盖默认的实现
+// 默认支持 JSON, XML, YAML 和纯文本
+func ServerWithCodecs(codecs ...Codec) HTTPServerOption {
+	return func(server *HTTPServer) {
+		for _, c := range codecs {
+			server.codecs.register(c)
+		}
+	}
+}
+
+// ServerWithDefaultContentType 客户端没有指定 Accept, 或者 Accept 里面的类型都不支持的时候
+// 使用的 Codec, 默认是 application/json
+func ServerWithDefaultContentType(contentType string) HTTPServerOption {
+	return func(server *HTTPServer) {
+		if _, ok := server.codecs.codecs[contentType]; !ok {
+			panic(fmt.Sprintf("web: 没有注册 %s 对应的 Codec", contentType))
+		}
+		server.codecs.defaultType = contentType
+	}
+}
+
+// Respond 按照请求的 Accept 头部选择 Codec 序列化 val
+// 和 RespJSON 一样, 只是设置 RespData 和 RespStatusCode, 最后才会写到响应里面
+func (c *Context) Respond(status int, val any) error {
+	codec := c.codecRegistry().negotiate(c.Req.Header.Get("Accept"))
+	data, err := codec.Marshal(val)
+	if err != nil {
+		return err
+	}
+	c.SetHeader("Content-Type", codec.ContentType())
+	c.RespData = data
+	c.RespStatusCode = status
+	return nil
+}
+
+func (c *Context) codecRegistry() *codecRegistry {
+	if c.codecs == nil {
+		// 用户自己创建的 Context, 例如测试里面
+		return defaultCodecRegistry
+	}
+	return c.codecs
+}
+
+var defaultCodecRegistry = newCodecRegistry()
+
+type codecRegistry struct {
+	// Content-Type 到 Codec 的映射
+	codecs map[string]Codec
+	// 注册的顺序, 遇到 application/* 这种的时候按照顺序找
+	order       []string
+	defaultType string
+}
+
+func newCodecRegistry() *codecRegistry {
+	res := &codecRegistry{
+		codecs:      map[string]Codec{},
+		defaultType: "application/json",
+	}
+	res.register(JSONCodec{})
+	res.register(XMLCodec{})
+	res.register(YAMLCodec{})
+	res.register(TextCodec{})
+	return res
+}
+
+// clone 每个 HTTPServer 都有自己的一份, 修改的时候不会影响别的 HTTPServer
+func (r *codecRegistry) clone() *codecRegistry {
+	res := &codecRegistry{
+		codecs:      make(map[string]Codec, len(r.codecs)),
+		order:       append([]string(nil), r.order...),
+		defaultType: r.defaultType,
+	}
+	for k, v := range r.codecs {
+		res.codecs[k] = v
+	}
+	return res
+}
+
+func (r *codecRegistry) register(c Codec) {
+	ct := c.ContentType()
+	if _, ok := r.codecs[ct]; !ok {
+		r.order = append(r.order, ct)
+	}
+	r.codecs[ct] = c
+}
+
+// get 按照请求的 Content-Type 找 Codec, 会忽略 charset 之类的参数
+func (r *codecRegistry) get(contentType string) (Codec, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	c, ok := r.codecs[mediaType]
+	return c, ok
+}
+
+// negotiate 按照 Accept 里面的 q 值从高到低找支持的 Codec, 都不支持就用默认的
+func (r *codecRegistry) negotiate(accept string) Codec {
+	for _, mediaType := range parseAccept(accept) {
+		if mediaType == "*/*" {
+			break
+		}
+		if c, ok := r.codecs[mediaType]; ok {
+			return c
+		}
+		if prefix, ok := strings.CutSuffix(mediaType, "/*"); ok {
+			for _, ct := range r.order {
+				if strings.HasPrefix(ct, prefix+"/") {
+					return r.codecs[ct]
+				}
+			}
+		}
+	}
+	return r.codecs[r.defaultType]
+}
+
+type acceptItem struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept 解析 Accept 头部, 按照 q 值从高到低排序, q=0 的会被去掉
+// 例如 text/html, application/json;q=0.9, */*;q=0.8
+func parseAccept(accept string) []string {
+	if accept == "" {
+		return nil
+	}
+	items := make([]acceptItem, 0, 4)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		items = append(items, acceptItem{mediaType: mediaType, q: q})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].q > items[j].q
+	})
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.mediaType)
+	}
+	return res
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+func (JSONCodec) Marshal(val any) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (JSONCodec) Unmarshal(data []byte, val any) error {
+	return json.Unmarshal(data, val)
+}
+
+type XMLCodec struct{}
+
+func (XMLCodec) ContentType() string {
+	return "application/xml"
+}
+
+func (XMLCodec) Marshal(val any) ([]byte, error) {
+	return xml.Marshal(val)
+}
+
+func (XMLCodec) Unmarshal(data []byte, val any) error {
+	return xml.Unmarshal(data, val)
+}
+
+type YAMLCodec struct{}
+
+func (YAMLCodec) ContentType() string {
+	return "application/yaml"
+}
+
+func (YAMLCodec) Marshal(val any) ([]byte, error) {
+	return yaml.Marshal(val)
+}
+
+func (YAMLCodec) Unmarshal(data []byte, val any) error {
+	return yaml.Unmarshal(data, val)
+}
+
+// TextCodec 纯文本
+// 序列化支持 string, []byte, fmt.Stringer 和 error, 别的类型用 fmt.Sprint
+// 反序列化支持 *string, *[]byte 和 encoding.TextUnmarshaler
+type TextCodec struct{}
+
+func (TextCodec) ContentType() string {
+	return "text/plain"
+}
+
+func (TextCodec) Marshal(val any) ([]byte, error) {
+	switch v := val.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	case error:
+		return []byte(v.Error()), nil
+	}
+	return []byte(fmt.Sprint(val)), nil
+}
+
+func (TextCodec) Unmarshal(data []byte, val any) error {
+	switch v := val.(type) {
+	case *string:
+		*v = string(data)
+		return nil
+	case *[]byte:
+		*v = append((*v)[:0], data...)
+		return nil
+	case encoding.TextUnmarshaler:
+		return v.UnmarshalText(data)
+	}
+	return fmt.Errorf("web: text/plain 不支持反序列化到 %T", val)
+}
+
+// ProtoMessage protobuf 生成的类型一般都有这两个方法, 例如 gogo/protobuf
+// 使用 google.golang.org/protobuf 的话, 可以用 proto.Marshal 和 proto.Unmarshal 包装一下
+type ProtoMessage interface {
+	Marshal() ([]byte, error)
+	Unmarshal(data []byte) error
+}
+
+var errNotProtoMessage = errors.New("web: 只支持实现了 ProtoMessage 的类型")
+
+// ProtobufCodec 默认没有注册, 需要的话用 ServerWithCodecs(ProtobufCodec{}) 注册
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (ProtobufCodec) Marshal(val any) ([]byte, error) {
+	msg, ok := val.(ProtoMessage)
+	if !ok {
+		return nil, errNotProtoMessage
+	}
+	return msg.Marshal()
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, val any) error {
+	msg, ok := val.(ProtoMessage)
+	if !ok {
+		return errNotProtoMessage
+	}
+	return msg.Unmarshal(data)
+}
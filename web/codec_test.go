@@ -0,0 +1,237 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type codecUser struct {
+	Name string `json:"name" xml:"name" yaml:"name"`
+	Age  int    `json:"age" xml:"age" yaml:"age"`
+}
+
+// mockProtoMessage 模拟 protobuf 生成的类型
+type mockProtoMessage struct {
+	data string
+}
+
+func (m *mockProtoMessage) Marshal() ([]byte, error) {
+	return []byte("proto:" + m.data), nil
+}
+
+func (m *mockProtoMessage) Unmarshal(data []byte) error {
+	m.data = strings.TrimPrefix(string(data), "proto:")
+	return nil
+}
+
+func TestContext_Respond(t *testing.T) {
+	testCases := []struct {
+		name     string
+		opts     []HTTPServerOption
+		accept   string
+		val      any
+		wantType string
+		wantBody string
+		wantErr  error
+	}{
+		{
+			name:     "no accept",
+			val:      codecUser{Name: "Tom", Age: 18},
+			wantType: "application/json",
+			wantBody: `{"name":"Tom","age":18}`,
+		},
+		{
+			name:     "xml",
+			accept:   "application/xml",
+			val:      codecUser{Name: "Tom", Age: 18},
+			wantType: "application/xml",
+			wantBody: `<codecUser><name>Tom</name><age>18</age></codecUser>`,
+		},
+		{
+			name:     "yaml",
+			accept:   "application/yaml",
+			val:      codecUser{Name: "Tom", Age: 18},
+			wantType: "application/yaml",
+			wantBody: "name: Tom\nage: 18\n",
+		},
+		{
+			name:     "text",
+			accept:   "text/plain",
+			val:      "hello",
+			wantType: "text/plain",
+			wantBody: "hello",
+		},
+		{
+			// 按照 q 值选, 不支持的 text/html 跳过
+			name:     "quality",
+			accept:   "text/html, application/json;q=0.5, application/xml;q=0.9",
+			val:      codecUser{Name: "Tom", Age: 18},
+			wantType: "application/xml",
+			wantBody: `<codecUser><name>Tom</name><age>18</age></codecUser>`,
+		},
+		{
+			name:     "wildcard subtype",
+			accept:   "text/*",
+			val:      "hello",
+			wantType: "text/plain",
+			wantBody: "hello",
+		},
+		{
+			name:     "not supported",
+			accept:   "text/html",
+			val:      codecUser{Name: "Tom", Age: 18},
+			wantType: "application/json",
+			wantBody: `{"name":"Tom","age":18}`,
+		},
+		{
+			name:     "custom default",
+			opts:     []HTTPServerOption{ServerWithDefaultContentType("application/yaml")},
+			accept:   "*/*",
+			val:      codecUser{Name: "Tom", Age: 18},
+			wantType: "application/yaml",
+			wantBody: "name: Tom\nage: 18\n",
+		},
+		{
+			name:     "protobuf",
+			opts:     []HTTPServerOption{ServerWithCodecs(ProtobufCodec{})},
+			accept:   "application/x-protobuf",
+			val:      &mockProtoMessage{data: "abc"},
+			wantType: "application/x-protobuf",
+			wantBody: "proto:abc",
+		},
+		{
+			name:    "protobuf not message",
+			opts:    []HTTPServerOption{ServerWithCodecs(ProtobufCodec{})},
+			accept:  "application/x-protobuf",
+			val:     codecUser{},
+			wantErr: errNotProtoMessage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewHTTPServer(tc.opts...)
+			var err error
+			s.Get("/user", func(ctx *Context) {
+				err = ctx.Respond(http.StatusCreated, tc.val)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/user", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			recorder := httptest.NewRecorder()
+			s.ServeHTTP(recorder, req)
+			assert.Equal(t, tc.wantErr, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, http.StatusCreated, recorder.Code)
+			assert.Equal(t, tc.wantType, recorder.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantBody, recorder.Body.String())
+		})
+	}
+}
+
+func TestContext_BindCodec(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		wantVal     codecUser
+		wantErr     bool
+	}{
+		{
+			name:        "json with charset",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"name":"Tom","age":18}`,
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:        "xml",
+			contentType: "application/xml",
+			body:        `<codecUser><name>Tom</name><age>18</age></codecUser>`,
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			name:        "yaml",
+			contentType: "application/yaml",
+			body:        "name: Tom\nage: 18\n",
+			wantVal:     codecUser{Name: "Tom", Age: 18},
+		},
+		{
+			// 没有对应的 Codec, 跳过 body
+			name:        "unknown content type",
+			contentType: "application/octet-stream",
+			body:        "abc",
+		},
+		{
+			name:        "invalid body",
+			contentType: "application/json",
+			body:        `{"name":`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			ctx := &Context{Req: req}
+			val := &codecUser{}
+			err := ctx.Bind(val)
+			if tc.wantErr {
+				var bindErr *BindError
+				require.True(t, errors.As(err, &bindErr))
+				assert.Equal(t, "body", bindErr.Source)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantVal, *val)
+		})
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	testCases := []struct {
+		name   string
+		accept string
+		want   []string
+	}{
+		{
+			name: "empty",
+			want: nil,
+		},
+		{
+			name:   "sorted by q",
+			accept: "text/html;q=0.8, application/json, */*;q=0.1",
+			want:   []string{"application/json", "text/html", "*/*"},
+		},
+		{
+			// 同样的 q 保持原本的顺序, q=0 代表不接受
+			name:   "stable and zero",
+			accept: "application/xml, application/json, text/plain;q=0",
+			want:   []string{"application/xml", "application/json"},
+		},
+		{
+			name:   "invalid q",
+			accept: "application/xml;q=abc, application/json",
+			want:   []string{"application/json"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := parseAccept(tc.accept)
+			if tc.want == nil {
+				assert.Empty(t, res)
+				return
+			}
+			assert.Equal(t, tc.want, res)
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package web
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 //var (
@@ -20,6 +23,10 @@ func (c *Context) Render(tplName string, data any) error {
 	// 不要这样做
 	// tplName = tplName + ".gohtml"
 	// tplName = tplName + c.tplPrefix
+	if c.tplEngine == nil {
+		c.RespStatusCode = http.StatusInternalServerError
+		return errNoTemplateEngine
+	}
 	var err error
 	c.RespData, err = c.tplEngine.Render(c.Req.Context(), tplName, data)
 	if err != nil {
@@ -44,6 +51,9 @@ type Context struct {
 	RespData []byte
 	// 响应状态码
 	RespStatusCode int
+	// 响应头部, 和 RespData 一样在 flashResp 里面才写到响应里面
+	// 所以 middleware 在 next 返回之后还可以修改
+	RespHeader http.Header
 
 	PathParams map[string]string
 
@@ -56,7 +66,29 @@ type Context struct {
 
 	tplEngine TemplateEngine
 
+	codecs *codecRegistry
+
+	// handler 返回的原始 error
+	err        error
+	errHandler ErrorHandler
+
+	// 建议使用 Key, 可以避免 key 冲突和类型断言
 	UserValues map[string]any
+	// Key 设置的值
+	values map[any]any
+
+	// 包装 Resp, 放在 Context 里面可以跟着 Context 一起复用
+	writer responseWriter
+
+	// 原始的 body, 路由上的 MaxBodySize 要用它重新设置大小限制
+	body io.ReadCloser
+	// RawBody 缓存的 body
+	rawBody []byte
+	// 当前生效的 body 大小限制, 路由上的 MaxBodySize 会覆盖 ServerWithMaxBodySize
+	maxBodySize int64
+
+	// Stream 和 SSE 打开的流, 请求结束的时候关闭
+	streamClosers []func()
 
 	// cookieSameSite http.SameSite
 }
@@ -65,6 +97,50 @@ type Context struct {
 //
 //}
 
+// init 从 pool 里面拿出来之后初始化
+func (c *Context) init(writer http.ResponseWriter, req *http.Request) {
+	c.Req = req
+	// 包装一下, 这样才知道用户有没有直接写响应
+	c.writer = responseWriter{ResponseWriter: writer}
+	c.Resp = &c.writer
+}
+
+// reset 放回 pool 之前清空, 避免下一个请求看到这个请求的数据
+// RespHeader 和 UserValues 保留 map 本身, 只删除里面的 key
+func (c *Context) reset() {
+	c.Req = nil
+	c.Resp = nil
+	c.writer = responseWriter{}
+	c.RespData = nil
+	c.RespStatusCode = 0
+	for key := range c.RespHeader {
+		delete(c.RespHeader, key)
+	}
+	c.PathParams = nil
+	c.queryValues = nil
+	c.body = nil
+	c.rawBody = nil
+	c.maxBodySize = 0
+	c.MatchedRoute = ""
+	for key := range c.UserValues {
+		delete(c.UserValues, key)
+	}
+	// 不能复用, Req.Context() 可能被别人持有了
+	c.values = nil
+	c.tplEngine = nil
+	c.codecs = nil
+	c.err = nil
+	c.errHandler = nil
+}
+
+// SetHeader 设置响应头部, 会覆盖 key 原本的值
+func (c *Context) SetHeader(key string, val string) {
+	if c.RespHeader == nil {
+		c.RespHeader = http.Header{}
+	}
+	c.RespHeader.Set(key, val)
+}
+
 // SetCookie 设置Cookie
 func (c *Context) SetCookie(ck *http.Cookie) {
 	// 不推荐
@@ -82,10 +158,12 @@ func (c *Context) RespJSON(status int, val any) error {
 	if err != nil {
 		return err
 	}
+	c.SetHeader("Content-Type", "application/json")
 	c.RespData = data
 	c.RespStatusCode = status
 
-	// 放到最后响应之前 (root(ctx)的之前)传入
+	// 放到最后响应之前 (root(ctx)的之前)传入, 也就是 flashResp 里面
+	// Content-Length 也是在 flashResp 里面按照 RespData 设置的
 	//c.Resp.WriteHeader(status)
 	//c.Resp.Header().Set("Content-Type", "application/json")
 	//c.Resp.Header().Set("Content-Length", strconv.Itoa(len(data)))
@@ -109,7 +187,12 @@ func (c *Context) BindJSON(val any) error {
 	// 不要这样写
 	//bs, _ := io.ReadAll(c.Req.Body)
 	//json.Unmarshal(bs, val)
-	decoder := json.NewDecoder(c.Req.Body)
+	// 从缓存里面读, 这样 BindJSON 之后别人还能读 body
+	body, err := c.RawBody()
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
 
 	// useNumber => 数字就会用 Number 来表示
 	// 否则默认是float64
@@ -126,7 +209,7 @@ func (c *Context) BindJSON(val any) error {
 // FormValue 从表单里拿数据
 func (c *Context) FormValue(key string) (string, error) {
 	//r.PostForm == nil  ParseForm()方法中的  不用担心重复ParseForm
-	err := c.Req.ParseForm()
+	err := c.parseForm()
 	if err != nil {
 		return "", err
 	}
@@ -197,11 +280,63 @@ func (c *Context) PathValueV1(key string) StringValue {
 	}
 }
 
+// HeaderValue 处理输入-读取Header
+func (c *Context) HeaderValue(key string) StringValue {
+	vals := c.Req.Header.Values(key)
+	if len(vals) == 0 {
+		return StringValue{
+			err: errors.New("web: key 不存在"),
+		}
+	}
+	return StringValue{
+		val: vals[0],
+	}
+}
+
+// CookieValue 处理输入-读取Cookie
+func (c *Context) CookieValue(name string) StringValue {
+	ck, err := c.Req.Cookie(name)
+	if err != nil {
+		return StringValue{
+			err: err,
+		}
+	}
+	return StringValue{
+		val: ck.Value,
+	}
+}
+
+// FormValueV1 和 FormValue 不一样的地方在于, 能区分出来是没有这个 key, 还是值恰好是空字符串
+func (c *Context) FormValueV1(key string) StringValue {
+	err := c.parseForm()
+	if err != nil {
+		return StringValue{
+			err: err,
+		}
+	}
+	vals, ok := c.Req.Form[key]
+	if !ok || len(vals) == 0 {
+		return StringValue{
+			err: errors.New("web: key 不存在"),
+		}
+	}
+	return StringValue{
+		val: vals[0],
+	}
+}
+
+// StringValue 输入的值默认都是 string, 用户需要的话就转化为别的类型
+// Context 不能声明泛型方法, 所以只能一个类型一个方法
 type StringValue struct {
 	val string
 	err error
 }
 
+// String 返回原始的值
+func (s StringValue) String() (string, error) {
+	return s.val, s.err
+}
+
 func (s StringValue) AsInt64() (int64, error) {
 	if s.err != nil {
 		return 0, s.err
@@ -209,6 +344,116 @@ func (s StringValue) AsInt64() (int64, error) {
 	return strconv.ParseInt(s.val, 10, 64)
 }
 
+func (s StringValue) AsInt() (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return strconv.Atoi(s.val)
+}
+
+func (s StringValue) AsUint64() (uint64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return strconv.ParseUint(s.val, 10, 64)
+}
+
+func (s StringValue) AsFloat64() (float64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return strconv.ParseFloat(s.val, 64)
+}
+
+// AsBool 支持 1, t, T, TRUE, true, True, 0, f, F, FALSE, false, False
+func (s StringValue) AsBool() (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return strconv.ParseBool(s.val)
+}
+
+// AsDuration 例如 1s, 300ms, 1h30m
+func (s StringValue) AsDuration() (time.Duration, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return time.ParseDuration(s.val)
+}
+
+// AsTime 按照 layout 解析时间, 例如 time.RFC3339
+func (s StringValue) AsTime(layout string) (time.Time, error) {
+	if s.err != nil {
+		return time.Time{}, s.err
+	}
+	return time.Parse(layout, s.val)
+}
+
+// 下面的 OrDefault 系列, 在没有这个值或者转换失败的时候返回默认值
+
+func (s StringValue) StringOrDefault(def string) string {
+	if s.err != nil {
+		return def
+	}
+	return s.val
+}
+
+func (s StringValue) Int64OrDefault(def int64) int64 {
+	res, err := s.AsInt64()
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+func (s StringValue) IntOrDefault(def int) int {
+	res, err := s.AsInt()
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+func (s StringValue) Uint64OrDefault(def uint64) uint64 {
+	res, err := s.AsUint64()
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+func (s StringValue) Float64OrDefault(def float64) float64 {
+	res, err := s.AsFloat64()
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+func (s StringValue) BoolOrDefault(def bool) bool {
+	res, err := s.AsBool()
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+func (s StringValue) DurationOrDefault(def time.Duration) time.Duration {
+	res, err := s.AsDuration()
+	if err != nil {
+		return def
+	}
+	return res
+}
+
+func (s StringValue) TimeOrDefault(layout string, def time.Time) time.Time {
+	res, err := s.AsTime(layout)
+	if err != nil {
+		return def
+	}
+	return res
+}
+
 /*
 	处理输入要解决的问题:
 		反序列化输入: 将 Body 字节流转换成一个具体的类型
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStringValue(t *testing.T) {
+	errNotFound := errors.New("web: key 不存在")
+
+	i, err := StringValue{val: "12"}.AsInt()
+	assert.NoError(t, err)
+	assert.Equal(t, 12, i)
+	_, err = StringValue{err: errNotFound}.AsInt()
+	assert.Equal(t, errNotFound, err)
+
+	u, err := StringValue{val: "12"}.AsUint64()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(12), u)
+	_, err = StringValue{val: "-12"}.AsUint64()
+	assert.Error(t, err)
+
+	f, err := StringValue{val: "1.5"}.AsFloat64()
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, f)
+
+	b, err := StringValue{val: "true"}.AsBool()
+	assert.NoError(t, err)
+	assert.True(t, b)
+	_, err = StringValue{val: "yes"}.AsBool()
+	assert.Error(t, err)
+
+	d, err := StringValue{val: "1m30s"}.AsDuration()
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, d)
+
+	tm, err := StringValue{val: "2023-06-01"}.AsTime(time.DateOnly)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), tm)
+
+	str, err := StringValue{val: "abc"}.String()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", str)
+
+	missing := StringValue{err: errNotFound}
+	assert.Equal(t, "def", missing.StringOrDefault("def"))
+	assert.Equal(t, int64(1), missing.Int64OrDefault(1))
+	assert.Equal(t, 1, StringValue{val: "abc"}.IntOrDefault(1))
+	assert.Equal(t, 12, StringValue{val: "12"}.IntOrDefault(1))
+	assert.Equal(t, uint64(1), missing.Uint64OrDefault(1))
+	assert.Equal(t, 1.5, missing.Float64OrDefault(1.5))
+	assert.True(t, missing.BoolOrDefault(true))
+	assert.Equal(t, time.Second, missing.DurationOrDefault(time.Second))
+	now := time.Now()
+	assert.Equal(t, now, missing.TimeOrDefault(time.DateOnly, now))
+}
+
+func TestContext_StringValueSource(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/user?page=2", strings.NewReader("name=zhangsan&age="))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Limit", "20")
+	req.AddCookie(&http.Cookie{Name: "uid", Value: "123"})
+	ctx := &Context{
+		Req:        req,
+		PathParams: map[string]string{"id": "12"},
+	}
+
+	assert.Equal(t, 20, ctx.HeaderValue("X-Limit").IntOrDefault(0))
+	_, err := ctx.HeaderValue("X-Token").String()
+	assert.Error(t, err)
+
+	uid, err := ctx.CookieValue("uid").AsUint64()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(123), uid)
+	_, err = ctx.CookieValue("sessid").String()
+	assert.Equal(t, http.ErrNoCookie, err)
+
+	name, err := ctx.FormValueV1("name").String()
+	assert.NoError(t, err)
+	assert.Equal(t, "zhangsan", name)
+	// 有这个 key, 但是值是空字符串
+	age, err := ctx.FormValueV1("age").String()
+	assert.NoError(t, err)
+	assert.Equal(t, "", age)
+	_, err = ctx.FormValueV1("email").String()
+	assert.Error(t, err)
+
+	assert.Equal(t, 2, ctx.QueryValueV1("page").IntOrDefault(1))
+	assert.Equal(t, int64(12), ctx.PathValueV1("id").Int64OrDefault(0))
+}
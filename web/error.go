@@ -0,0 +1,172 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// HandleErrFunc 返回 error 的 handler
+// 返回的 error 交给 HTTPServer 上的 ErrorHandler 统一处理, 不用自己设置 RespStatusCode 和 RespData
+type HandleErrFunc func(ctx *Context) error
+
+// WrapErr 把 HandleErrFunc 转成 HandleFunc, 这样就可以用原本的方法注册路由
+//
+//	h.Get("/user/:id", web.WrapErr(func(ctx *web.Context) error {
+//		u, err := dao.GetUser(ctx.Req.Context(), id)
+//		if errors.Is(err, dao.ErrNotFound) {
+//			return web.NewHTTPError(http.StatusNotFound, "用户不存在", err)
+//		}
+//		if err != nil {
+//			return err
+//		}
+//		return ctx.RespJSONOK(u)
+//	}))
+func WrapErr(fn HandleErrFunc) HandleFunc {
+	return func(ctx *Context) {
+		if err := fn(ctx); err != nil {
+			ctx.Error(err)
+		}
+	}
+}
+
+// HandleErr 和 h.Get(path, WrapErr(fn)) 之类的效果一样
+// 区别是 Routes 里面显示的是 fn 的名字, 而不是 WrapErr 里面的闭包
+func (h *HTTPServer) HandleErr(method string, path string, fn HandleErrFunc, mdls ...Middleware) {
+	h.addNamedRoute(method, path, funcName(fn), WrapErr(fn), mdls...)
+}
+
+// HandleErr 在分组下注册返回 error 的 handler
+func (g *RouteGroup) HandleErr(method string, path string, fn HandleErrFunc, mdls ...Middleware) {
+	g.server.addNamedRoute(method, g.fullPath(path), funcName(fn), WrapErr(fn), g.joinMiddlewares(mdls)...)
+}
+
+// HTTPError 带上了响应码的 error
+// Message 会返回给客户端, Cause 不会, 只用于日志之类的
+type HTTPError struct {
+	Code    int
+	Message string
+	Cause   error
+}
+
+func NewHTTPError(code int, msg string, cause error) *HTTPError {
+	return &HTTPError{
+		Code:    code,
+		Message: msg,
+		Cause:   cause,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("web: %d %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("web: %d %s: %v", e.Code, e.Message, e.Cause)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Cause
+}
+
+// ErrorHandler 统一处理 handler 返回的 error, 负责设置 RespStatusCode 和 RespData
+type ErrorHandler func(ctx *Context, err error)
+
+// ServerWithErrorHandler 替换默认的 ErrorHandler
+func ServerWithErrorHandler(handler ErrorHandler) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.errHandler = handler
+	}
+}
+
+// ServerWithErrorTemplate 浏览器的请求(Accept 里面优先 text/html)用这个模板渲染错误页面
+// 模板的数据是 ErrorResp, 需要同时设置 ServerWithTemplateEngine
+func ServerWithErrorTemplate(tplName string) HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.errTplName = tplName
+	}
+}
+
+// Error 记录 error 并且交给 ErrorHandler 生成响应
+// 后面的 middleware 可以通过 Err 拿到原始的 error, 例如打日志
+func (c *Context) Error(err error) {
+	c.err = err
+	if c.errHandler != nil {
+		c.errHandler(c, err)
+		return
+	}
+	// 用户自己创建的 Context, 例如测试里面
+	defaultErrorHandler(c, err, "")
+}
+
+// Err 返回 handler 的原始 error, 没有的话返回 nil
+func (c *Context) Err() error {
+	return c.err
+}
+
+// ErrorResp 默认的 ErrorHandler 返回给客户端的数据
+type ErrorResp struct {
+	Code    int    `json:"code" xml:"code" yaml:"code"`
+	Message string `json:"message" xml:"message" yaml:"message"`
+	// 参数校验失败的时候, 每个字段的错误
+	Errors ValidationErrors `json:"errors,omitempty" xml:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+func (h *HTTPServer) handleError(ctx *Context, err error) {
+	defaultErrorHandler(ctx, err, h.errTplName)
+}
+
+// defaultErrorHandler 按照 error 的类型决定响应码
+// HTTPError 用它自己的响应码和信息, 参数绑定和校验失败是 400, body 太大是 413
+// 其它的都是 500, 并且不会把 error 的内容返回给客户端
+// 浏览器的请求, 并且设置了错误模板的时候渲染页面, 否则按照 Accept 选择 Codec
+func defaultErrorHandler(ctx *Context, err error, tplName string) {
+	resp := ErrorResp{
+		Code:    http.StatusInternalServerError,
+		Message: http.StatusText(http.StatusInternalServerError),
+	}
+	var (
+		httpErr     *HTTPError
+		ves         ValidationErrors
+		bindErr     *BindError
+		maxBytesErr *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &httpErr):
+		resp.Code, resp.Message = httpErr.Code, httpErr.Message
+	case errors.As(err, &ves):
+		resp.Code, resp.Message, resp.Errors = http.StatusBadRequest, "参数校验失败", ves
+	case errors.As(err, &maxBytesErr):
+		resp.Code, resp.Message = http.StatusRequestEntityTooLarge, http.StatusText(http.StatusRequestEntityTooLarge)
+	case errors.As(err, &bindErr):
+		resp.Code, resp.Message = http.StatusBadRequest, bindErr.publicMessage()
+	}
+
+	if tplName != "" && ctx.tplEngine != nil && prefersHTML(ctx.Req) {
+		data, tplErr := ctx.tplEngine.Render(ctx.Req.Context(), tplName, resp)
+		if tplErr == nil {
+			ctx.SetHeader("Content-Type", "text/html; charset=utf-8")
+			ctx.RespStatusCode = resp.Code
+			ctx.RespData = data
+			return
+		}
+	}
+	if ctx.Respond(resp.Code, resp) != nil {
+		ctx.RespStatusCode = resp.Code
+		ctx.RespData = []byte(resp.Message)
+	}
+}
+
+// prefersHTML Accept 里面优先级最高的是 text/html
+func prefersHTML(req *http.Request) bool {
+	return PreferredMediaType(req) == "text/html"
+}
+
+// PreferredMediaType Accept 里面 q 值最高的媒体类型, 例如 text/html 或者 */*
+// 没有 Accept 头部或者解析不出来的时候返回空字符串
+func PreferredMediaType(req *http.Request) string {
+	accepts := parseAccept(req.Header.Get("Accept"))
+	if len(accepts) == 0 {
+		return ""
+	}
+	return accepts[0]
+}
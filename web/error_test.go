@@ -0,0 +1,185 @@
+package web
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	cause := errors.New("record not found")
+	err := NewHTTPError(http.StatusNotFound, "用户不存在", cause)
+	assert.Equal(t, "web: 404 用户不存在: record not found", err.Error())
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, "web: 400 参数错误", NewHTTPError(http.StatusBadRequest, "参数错误", nil).Error())
+}
+
+func TestHTTPServer_ErrorHandler(t *testing.T) {
+	tpl, err := template.New("error").Parse(`<h1>{{.Code}} {{.Message}}</h1>`)
+	require.NoError(t, err)
+	dbErr := errors.New("db: connection refused")
+	bindErr := &BindError{Field: "ID", Source: "query", Key: "id", Err: strconv.ErrSyntax}
+
+	testCases := []struct {
+		name     string
+		opts     []HTTPServerOption
+		accept   string
+		body     string
+		handler  HandleErrFunc
+		wantErr  error
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{
+			name: "no error",
+			handler: func(ctx *Context) error {
+				return ctx.RespJSONOK("ok")
+			},
+			wantCode: http.StatusOK,
+			wantType: "application/json",
+			wantBody: `"ok"`,
+		},
+		{
+			name: "http error",
+			handler: func(ctx *Context) error {
+				return NewHTTPError(http.StatusNotFound, "用户不存在", dbErr)
+			},
+			wantErr:  NewHTTPError(http.StatusNotFound, "用户不存在", dbErr),
+			wantCode: http.StatusNotFound,
+			wantType: "application/json",
+			wantBody: `{"code":404,"message":"用户不存在"}`,
+		},
+		{
+			// 不会把原始的 error 返回给客户端
+			name: "internal error",
+			handler: func(ctx *Context) error {
+				return dbErr
+			},
+			wantErr:  dbErr,
+			wantCode: http.StatusInternalServerError,
+			wantType: "application/json",
+			wantBody: `{"code":500,"message":"Internal Server Error"}`,
+		},
+		{
+			name: "validation error",
+			body: `{"email":"abc"}`,
+			handler: func(ctx *Context) error {
+				val := &struct {
+					Email string `json:"email" validate:"email"`
+				}{}
+				if err := ctx.Bind(val); err != nil {
+					return err
+				}
+				return Validate(val)
+			},
+			wantErr: ValidationErrors{
+				{Field: "email", Rule: "email", Message: "email 不是合法的邮箱"},
+			},
+			wantCode: http.StatusBadRequest,
+			wantType: "application/json",
+			wantBody: `{"code":400,"message":"参数校验失败","errors":[{"field":"email","rule":"email","message":"email 不是合法的邮箱"}]}`,
+		},
+		{
+			// 不会把 strconv 之类的原始错误返回给客户端
+			name: "bind error",
+			handler: func(ctx *Context) error {
+				return bindErr
+			},
+			wantErr:  bindErr,
+			wantCode: http.StatusBadRequest,
+			wantType: "application/json",
+			wantBody: `{"code":400,"message":"参数格式错误, 来源 query[id]"}`,
+		},
+		{
+			name:   "accept xml",
+			accept: "application/xml",
+			handler: func(ctx *Context) error {
+				return NewHTTPError(http.StatusForbidden, "没有权限", nil)
+			},
+			wantErr:  NewHTTPError(http.StatusForbidden, "没有权限", nil),
+			wantCode: http.StatusForbidden,
+			wantType: "application/xml",
+			wantBody: `<ErrorResp><code>403</code><message>没有权限</message></ErrorResp>`,
+		},
+		{
+			name: "template",
+			opts: []HTTPServerOption{
+				ServerWithTemplateEngine(&GoTemplateEngine{T: tpl}),
+				ServerWithErrorTemplate("error"),
+			},
+			accept: "text/html,application/xhtml+xml,*/*;q=0.8",
+			handler: func(ctx *Context) error {
+				return NewHTTPError(http.StatusNotFound, "页面不存在", nil)
+			},
+			wantErr:  NewHTTPError(http.StatusNotFound, "页面不存在", nil),
+			wantCode: http.StatusNotFound,
+			wantType: "text/html; charset=utf-8",
+			wantBody: `<h1>404 页面不存在</h1>`,
+		},
+		{
+			// 不是浏览器的请求, 依旧返回 JSON
+			name: "template not html",
+			opts: []HTTPServerOption{
+				ServerWithTemplateEngine(&GoTemplateEngine{T: tpl}),
+				ServerWithErrorTemplate("error"),
+			},
+			handler: func(ctx *Context) error {
+				return NewHTTPError(http.StatusNotFound, "页面不存在", nil)
+			},
+			wantErr:  NewHTTPError(http.StatusNotFound, "页面不存在", nil),
+			wantCode: http.StatusNotFound,
+			wantType: "application/json",
+			wantBody: `{"code":404,"message":"页面不存在"}`,
+		},
+		{
+			name: "custom error handler",
+			opts: []HTTPServerOption{
+				ServerWithErrorHandler(func(ctx *Context, err error) {
+					ctx.RespStatusCode = http.StatusTeapot
+					ctx.RespData = []byte("custom: " + err.Error())
+				}),
+			},
+			handler: func(ctx *Context) error {
+				return dbErr
+			},
+			wantErr:  dbErr,
+			wantCode: http.StatusTeapot,
+			wantType: "text/plain; charset=utf-8",
+			wantBody: "custom: db: connection refused",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// 模拟打日志的 middleware, 能拿到原始的 error
+			var loggedErr error
+			opts := append([]HTTPServerOption{ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+				return func(ctx *Context) {
+					next(ctx)
+					loggedErr = ctx.Err()
+				}
+			})}, tc.opts...)
+			server := NewHTTPServer(opts...)
+			server.Post("/user", WrapErr(tc.handler))
+
+			req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantErr, loggedErr)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantType, resp.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 )
 
 type FileUploader struct {
@@ -211,22 +211,23 @@ func (s *StaticResourceHandler) Handle(ctx *Context) {
 	// 3.返回给前端
 
 	// 有缓存
+	// 注册成 /static/*file 的时候, file 可以是多段的, 例如 css/app.css
 	file, err := ctx.PathValue("file")
 	if err != nil {
 		ctx.RespStatusCode = http.StatusBadRequest
 		ctx.RespData = []byte("请求路径不对")
 		return
 	}
+	// 先按照绝对路径清理一遍, 防止 ../ 跳出 dir
+	file = filepath.Clean("/" + file)
 
 	dst := filepath.Join(s.dir, file)
-	ext := filepath.Ext(dst)[1:]
-	header := ctx.Resp.Header()
+	ext := strings.TrimPrefix(filepath.Ext(dst), ".")
 
 	if data, ok := s.cache.Get(file); ok {
-		header := ctx.Resp.Header()
 		// 可能的有 文本文件, 图片, 多媒体(视频, 音频)
-		header.Set("Content-Type", s.extensionContentTypeMap[ext])
-		header.Set("Content-Length", strconv.Itoa(len(data.([]byte))))
+		// Content-Length 在 flashResp 里面设置
+		ctx.SetHeader("Content-Type", s.extensionContentTypeMap[ext])
 		ctx.RespData = data.([]byte)
 		ctx.RespStatusCode = 200
 		return
@@ -237,6 +238,7 @@ func (s *StaticResourceHandler) Handle(ctx *Context) {
 	if err != nil {
 		ctx.RespStatusCode = http.StatusInternalServerError
 		ctx.RespData = []byte("服务器错误")
+		return
 	}
 
 	// 大文件不缓存
@@ -244,8 +246,7 @@ func (s *StaticResourceHandler) Handle(ctx *Context) {
 		s.cache.Add(file, data)
 	}
 	// 可能的有 文本文件, 图片, 多媒体(视频, 音频)
-	header.Set("Content-Type", s.extensionContentTypeMap[ext])
-	header.Set("Content-Length", strconv.Itoa(len(data)))
+	ctx.SetHeader("Content-Type", s.extensionContentTypeMap[ext])
 	ctx.RespData = data
 	ctx.RespStatusCode = 200
 
@@ -0,0 +1,81 @@
+package web
+
+import "net/http"
+
+// RouteGroup 路由分组
+// 同一个分组下的路由共享同一个前缀, 并且共享分组上的 middleware
+// 分组的 middleware 是挂在路由树的节点上的, 所以只有命中了这个分组下的路由才会执行
+// 例如 h.Group("/admin", authMdl) 只有 /admin/** 的请求才会执行 authMdl
+type RouteGroup struct {
+	prefix string
+	mdls   []Middleware
+	server *HTTPServer
+}
+
+// prefix 的限制和路由一样: 必须以 / 开头, 不能以 / 结尾
+func newRouteGroup(server *HTTPServer, prefix string, mdls []Middleware) *RouteGroup {
+	if prefix == "" || prefix[0] != '/' {
+		panic("web: 分组前缀必须以 / 开头")
+	}
+	if prefix != "/" && prefix[len(prefix)-1] == '/' {
+		panic("web: 分组前缀不能以 / 结尾")
+	}
+	return &RouteGroup{
+		prefix: prefix,
+		mdls:   mdls,
+		server: server,
+	}
+}
+
+// Group 创建嵌套的子分组
+// 子分组会先执行父分组的 middleware, 再执行自己的
+func (g *RouteGroup) Group(prefix string, mdls ...Middleware) *RouteGroup {
+	res := newRouteGroup(g.server, g.fullPath(prefix), nil)
+	res.mdls = g.joinMiddlewares(mdls)
+	return res
+}
+
+func (g *RouteGroup) Get(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.addRoute(http.MethodGet, path, handleFunc, mdls...)
+}
+func (g *RouteGroup) Post(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.addRoute(http.MethodPost, path, handleFunc, mdls...)
+}
+func (g *RouteGroup) Put(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.addRoute(http.MethodPut, path, handleFunc, mdls...)
+}
+func (g *RouteGroup) Options(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.addRoute(http.MethodOptions, path, handleFunc, mdls...)
+}
+func (g *RouteGroup) Delete(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.addRoute(http.MethodDelete, path, handleFunc, mdls...)
+}
+func (g *RouteGroup) Patch(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.addRoute(http.MethodPatch, path, handleFunc, mdls...)
+}
+func (g *RouteGroup) Head(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.addRoute(http.MethodHead, path, handleFunc, mdls...)
+}
+
+func (g *RouteGroup) addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware) {
+	g.server.addRoute(method, g.fullPath(path), handleFunc, g.joinMiddlewares(mdls)...)
+}
+
+// fullPath 拼接分组前缀, path 的校验交给 addRoute
+func (g *RouteGroup) fullPath(path string) string {
+	if g.prefix == "/" {
+		return path
+	}
+	if path == "/" {
+		return g.prefix
+	}
+	return g.prefix + path
+}
+
+// joinMiddlewares 分组的 middleware 在前, 路由自己的在后
+// 每次都复制一份, 避免多个路由共享同一个底层数组
+func (g *RouteGroup) joinMiddlewares(mdls []Middleware) []Middleware {
+	res := make([]Middleware, 0, len(g.mdls)+len(mdls))
+	res = append(res, g.mdls...)
+	return append(res, mdls...)
+}
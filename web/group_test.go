@@ -0,0 +1,97 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteGroup(t *testing.T) {
+	var logs []string
+	mdlBuilder := func(name string) Middleware {
+		return func(next HandleFunc) HandleFunc {
+			return func(ctx *Context) {
+				logs = append(logs, name+":"+ctx.MatchedRoute)
+				next(ctx)
+			}
+		}
+	}
+
+	h := NewHTTPServer()
+	h.Get("/user", func(ctx *Context) {})
+
+	admin := h.Group("/admin", mdlBuilder("admin"))
+	admin.Get("/", func(ctx *Context) {})
+	admin.Get("/user/:id", func(ctx *Context) {
+		ctx.RespData = []byte(ctx.PathParams["id"])
+	}, mdlBuilder("route"))
+
+	order := admin.Group("/order", mdlBuilder("order"))
+	order.Post("/create", func(ctx *Context) {})
+
+	testCases := []struct {
+		name     string
+		method   string
+		path     string
+		wantLogs []string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "no group",
+			method:   http.MethodGet,
+			path:     "/user",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "group root",
+			method:   http.MethodGet,
+			path:     "/admin",
+			wantLogs: []string{"admin:/admin"},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "group with route middleware",
+			method:   http.MethodGet,
+			path:     "/admin/user/12",
+			wantLogs: []string{"admin:/admin/user/:id", "route:/admin/user/:id"},
+			wantCode: http.StatusOK,
+			wantBody: "12",
+		},
+		{
+			name:     "nested group",
+			method:   http.MethodPost,
+			path:     "/admin/order/create",
+			wantLogs: []string{"admin:/admin/order/create", "order:/admin/order/create"},
+			wantCode: http.StatusOK,
+		},
+		{
+			// 没有命中路由, 分组的 middleware 不会执行
+			name:     "not found",
+			method:   http.MethodGet,
+			path:     "/admin/abc",
+			wantCode: http.StatusNotFound,
+			wantBody: "NOT FOUND",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs = nil
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			resp := httptest.NewRecorder()
+			h.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantLogs, logs)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+		})
+	}
+
+	assert.Panicsf(t, func() {
+		h.Group("admin")
+	}, "web: 分组前缀必须以 / 开头")
+	assert.Panicsf(t, func() {
+		h.Group("/admin/")
+	}, "web: 分组前缀不能以 / 结尾")
+}
@@ -0,0 +1,79 @@
+package web
+
+import "context"
+
+// Key 类型安全的 key, 用来在 middleware 和 handler 之间传递数据
+// 每次 NewKey 都会创建一个新的 key, 即便名字一样也不会冲突
+// 一般定义成包变量, 例如
+//
+//	var UserKey = web.NewKey[*User]("user")
+//
+//	// 鉴权的 middleware
+//	UserKey.Set(ctx, user)
+//
+//	// handler
+//	user, ok := UserKey.Get(ctx)
+//
+// 设置的值同时也能通过 ctx.Req.Context() 拿到, 例如
+//
+//	user, ok := UserKey.FromContext(ctx.Req.Context())
+type Key[T any] struct {
+	// 只用于调试, 区分 key 靠的是指针
+	name string
+}
+
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+func (k *Key[T]) String() string {
+	return k.name
+}
+
+// Get 先从 Context 里面找, 找不到再从 Req.Context() 里面找
+// 后者是为了兼容直接用 context.WithValue 设置的值
+func (k *Key[T]) Get(ctx *Context) (T, bool) {
+	if val, ok := ctx.values[k]; ok {
+		return val.(T), true
+	}
+	return k.FromContext(ctx.Req.Context())
+}
+
+// MustGet 没有值的时候 panic, 用于一定会被 middleware 设置的值
+func (k *Key[T]) MustGet(ctx *Context) T {
+	val, ok := k.Get(ctx)
+	if !ok {
+		panic("web: 没有设置 " + k.name)
+	}
+	return val
+}
+
+// Set 设置值, 和 UserValues 一样不是并发安全的
+func (k *Key[T]) Set(ctx *Context, val T) {
+	if ctx.values == nil {
+		ctx.values = make(map[any]any, 4)
+		// 只包装一次 Req.Context(), 后面的 Set 都直接写 map
+		ctx.Req = ctx.Req.WithContext(&valuesCtx{Context: ctx.Req.Context(), values: ctx.values})
+	}
+	ctx.values[k] = val
+}
+
+// FromContext 给只能拿到 context.Context 的代码用, 例如 orm 或者 rpc 客户端的拦截器
+func (k *Key[T]) FromContext(c context.Context) (T, bool) {
+	val, ok := c.Value(k).(T)
+	return val, ok
+}
+
+// valuesCtx 让 Req.Context().Value 能够拿到 Key 设置的值
+// 持有的是 map 本身而不是 Context, 因为 Context 会被复用
+type valuesCtx struct {
+	context.Context
+	values map[any]any
+}
+
+func (c *valuesCtx) Value(key any) any {
+	if val, ok := c.values[key]; ok {
+		return val
+	}
+	return c.Context.Value(key)
+}
@@ -0,0 +1,65 @@
+package web
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type keyUser struct {
+	Name string
+}
+
+func TestKey(t *testing.T) {
+	userKey := NewKey[*keyUser]("user")
+	// 名字一样也是不同的 key
+	otherUserKey := NewKey[*keyUser]("user")
+	idKey := NewKey[int64]("id")
+
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			userKey.Set(ctx, &keyUser{Name: "Tom"})
+			idKey.Set(ctx, 12)
+			next(ctx)
+		}
+	}))
+	server.Get("/user", func(ctx *Context) {
+		user, ok := userKey.Get(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, &keyUser{Name: "Tom"}, user)
+		assert.Equal(t, int64(12), idKey.MustGet(ctx))
+
+		_, ok = otherUserKey.Get(ctx)
+		assert.False(t, ok)
+		assert.Panics(t, func() {
+			otherUserKey.MustGet(ctx)
+		})
+
+		// 只能拿到 context.Context 的代码
+		user, ok = userKey.FromContext(ctx.Req.Context())
+		assert.True(t, ok)
+		assert.Equal(t, "Tom", user.Name)
+
+		// 后面的 Set 也能在 Req.Context() 里面看到
+		idKey.Set(ctx, 13)
+		id, ok := idKey.FromContext(ctx.Req.Context())
+		assert.True(t, ok)
+		assert.Equal(t, int64(13), id)
+	})
+	server.Get("/raw", func(ctx *Context) {
+		// 兼容直接用 context.WithValue 设置的值
+		ctx.Req = ctx.Req.WithContext(context.WithValue(ctx.Req.Context(), otherUserKey, &keyUser{Name: "Jerry"}))
+		user, ok := otherUserKey.Get(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "Jerry", user.Name)
+	})
+
+	for _, path := range []string{"/user", "/raw", "/user"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+	}
+}
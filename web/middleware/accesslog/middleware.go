@@ -2,11 +2,61 @@ package accesslog
 
 import (
 	"encoding/json"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"math/rand"
 	"my-frame/web"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// 可以用 Fields 选择的字段, 也是 JSON 和 logfmt 里面的 key
+const (
+	FieldTime       = "time"
+	FieldHost       = "host"
+	FieldRoute      = "route"
+	FieldHTTPMethod = "http_method"
+	FieldPath       = "path"
+	FieldStatus     = "status"
+	FieldDuration   = "duration"
+	FieldBytes      = "bytes"
+	FieldClientIP   = "client_ip"
+	FieldUserAgent  = "user_agent"
+	FieldReferer    = "referer"
+	FieldTraceID    = "trace_id"
+	FieldRequestID  = "request_id"
+	FieldError      = "error"
+)
+
+// defaultFields 默认输出的字段, 值为空的会被忽略
+var defaultFields = []string{
+	FieldTime, FieldHost, FieldRoute, FieldHTTPMethod, FieldPath, FieldStatus, FieldDuration,
+	FieldBytes, FieldClientIP, FieldUserAgent, FieldReferer, FieldTraceID, FieldRequestID, FieldError,
+}
+
+// Formatter 把一条访问日志格式化成字符串, fields 是 Fields 选择的字段
+type Formatter func(l *Entry, fields []string) string
+
 type MiddlewareBuilder struct {
-	logFunc func(log string)
+	logFunc   func(log string)
+	formatter Formatter
+	fields    []string
+	// 请求 ID 所在的头部, 先找请求的, 再找响应的
+	requestIDHeader string
+	// 采样率, 出错的请求不受影响
+	sampleRate float64
+	// 只记录出错的请求, 响应码 >= 400 或者 handler 返回了 error
+	errorsOnly bool
+	// 只记录慢请求, 和 errorsOnly 一起用的时候满足一个就记录
+	slowThreshold time.Duration
+}
+
+func NewMiddlewareBuilder() *MiddlewareBuilder {
+	return &MiddlewareBuilder{}
 }
 
 func (m *MiddlewareBuilder) LogFunc(fn func(log string)) *MiddlewareBuilder {
@@ -14,32 +64,262 @@ func (m *MiddlewareBuilder) LogFunc(fn func(log string)) *MiddlewareBuilder {
 	return m
 }
 
+// Formatter 默认是 JSONFormatter
+func (m *MiddlewareBuilder) Formatter(f Formatter) *MiddlewareBuilder {
+	m.formatter = f
+	return m
+}
+
+// Fields 选择要输出的字段, 例如 Fields(FieldPath, FieldStatus, FieldDuration)
+// CombinedFormatter 的格式是固定的, 不受影响
+func (m *MiddlewareBuilder) Fields(fields ...string) *MiddlewareBuilder {
+	m.fields = fields
+	return m
+}
+
+// RequestIDHeader 默认是 X-Request-Id
+func (m *MiddlewareBuilder) RequestIDHeader(header string) *MiddlewareBuilder {
+	m.requestIDHeader = header
+	return m
+}
+
+// SampleRate 只记录一部分请求, 取值 (0, 1), 出错的请求一定会记录
+// 默认是不采样, 全部记录
+func (m *MiddlewareBuilder) SampleRate(rate float64) *MiddlewareBuilder {
+	m.sampleRate = rate
+	return m
+}
+
+// ErrorsOnly 只记录出错的请求
+func (m *MiddlewareBuilder) ErrorsOnly() *MiddlewareBuilder {
+	m.errorsOnly = true
+	return m
+}
+
+// SlowThreshold 只记录耗时超过 threshold 的请求
+func (m *MiddlewareBuilder) SlowThreshold(threshold time.Duration) *MiddlewareBuilder {
+	m.slowThreshold = threshold
+	return m
+}
+
 func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.logFunc == nil {
+		m.logFunc = func(l string) {
+			log.Println(l)
+		}
+	}
+	if m.formatter == nil {
+		m.formatter = JSONFormatter
+	}
+	if len(m.fields) == 0 {
+		m.fields = defaultFields
+	}
+	if m.requestIDHeader == "" {
+		m.requestIDHeader = "X-Request-Id"
+	}
+	sample := m.sampleRate > 0 && m.sampleRate < 1
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
+			start := time.Now()
 			// 记录请求
 			defer func() {
-				l := accesslog{
-					Host:       ctx.Req.Host,
-					Route:      ctx.MatchedRoute,
-					HTTPMethod: ctx.Req.Method,
-					Path:       ctx.Req.URL.Path,
+				duration := time.Since(start)
+				isErr := ctx.RespStatusCode >= http.StatusBadRequest || ctx.Err() != nil
+				if (m.errorsOnly || m.slowThreshold > 0) &&
+					!(m.errorsOnly && isErr) && !(m.slowThreshold > 0 && duration >= m.slowThreshold) {
+					return
 				}
-				data, _ := json.Marshal(l)
-				m.logFunc(string(data))
-
+				if sample && !isErr && rand.Float64() >= m.sampleRate {
+					return
+				}
+				l := m.entry(ctx, start, duration)
+				m.logFunc(m.formatter(l, m.fields))
 			}()
 			next(ctx)
 		}
 	}
 }
 
-type accesslog struct {
-	Host string `json:"host,omitempty"`
+func (m MiddlewareBuilder) entry(ctx *web.Context, start time.Time, duration time.Duration) *Entry {
+	status := ctx.RespStatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	l := &Entry{
+		Time:       start,
+		Host:       ctx.Req.Host,
+		Route:      ctx.MatchedRoute,
+		HTTPMethod: ctx.Req.Method,
+		Path:       ctx.Req.URL.Path,
+		Query:      ctx.Req.URL.RawQuery,
+		Proto:      ctx.Req.Proto,
+		Status:     status,
+		Duration:   duration,
+		Bytes:      ctx.RespSize(),
+		ClientIP:   clientIP(ctx.Req),
+		UserAgent:  ctx.Req.UserAgent(),
+		Referer:    ctx.Req.Referer(),
+		RequestID:  ctx.Req.Header.Get(m.requestIDHeader),
+	}
+	if l.RequestID == "" {
+		l.RequestID = ctx.RespHeader.Get(m.requestIDHeader)
+	}
+	// opentelemetry 的 middleware 会把 span 放到 ctx.Req 里面, 所以要在 next 之后取
+	if sc := trace.SpanContextFromContext(ctx.Req.Context()); sc.HasTraceID() {
+		l.TraceID = sc.TraceID().String()
+	}
+	if err := ctx.Err(); err != nil {
+		l.Error = err.Error()
+	}
+	return l
+}
+
+// clientIP 优先使用 X-Forwarded-For 里面的第一个, 也就是最初的客户端
+// 注意这个头部可以被客户端伪造, 只能用于记录日志
+func clientIP(req *http.Request) string {
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		ip, _, _ := strings.Cut(xff, ",")
+		if ip = strings.TrimSpace(ip); ip != "" {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-Ip")); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// Entry 一条访问日志, 自己实现 Formatter 的时候可以用到全部的数据
+type Entry struct {
+	Time time.Time
+	Host string
 	// 命中的路由
-	Route      string `json:"route,omitempty"`
-	HTTPMethod string `json:"http_method,omitempty"`
-	Path       string `json:"path,omitempty"`
+	Route      string
+	HTTPMethod string
+	Path       string
+	Query      string
+	Proto      string
+	Status     int
+	Duration   time.Duration
+	// 响应 body 的字节数
+	Bytes     int
+	ClientIP  string
+	UserAgent string
+	Referer   string
+	TraceID   string
+	RequestID string
+	// handler 返回的 error
+	Error string
+}
+
+// value 字段的值, 数字之外的都是字符串, 第二个返回值为 false 代表值为空
+func (l *Entry) value(field string) (any, bool) {
+	var str string
+	switch field {
+	case FieldTime:
+		str = l.Time.Format(time.RFC3339Nano)
+	case FieldHost:
+		str = l.Host
+	case FieldRoute:
+		str = l.Route
+	case FieldHTTPMethod:
+		str = l.HTTPMethod
+	case FieldPath:
+		str = l.Path
+	case FieldStatus:
+		return l.Status, true
+	case FieldDuration:
+		str = l.Duration.String()
+	case FieldBytes:
+		return l.Bytes, true
+	case FieldClientIP:
+		str = l.ClientIP
+	case FieldUserAgent:
+		str = l.UserAgent
+	case FieldReferer:
+		str = l.Referer
+	case FieldTraceID:
+		str = l.TraceID
+	case FieldRequestID:
+		str = l.RequestID
+	case FieldError:
+		str = l.Error
+	}
+	return str, str != ""
+}
+
+// JSONFormatter 例如 {"host":"localhost","http_method":"GET","path":"/user","status":200}
+// 按照 fields 的顺序输出
+func JSONFormatter(l *Entry, fields []string) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for _, field := range fields {
+		val, ok := l.value(field)
+		if !ok {
+			continue
+		}
+		if sb.Len() > 1 {
+			sb.WriteByte(',')
+		}
+		key, _ := json.Marshal(field)
+		data, _ := json.Marshal(val)
+		sb.Write(key)
+		sb.WriteByte(':')
+		sb.Write(data)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// LogfmtFormatter 例如 host=localhost http_method=GET path=/user status=200
+// 带空格或者引号的值会加上引号
+func LogfmtFormatter(l *Entry, fields []string) string {
+	var sb strings.Builder
+	for _, field := range fields {
+		val, ok := l.value(field)
+		if !ok {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(field)
+		sb.WriteByte('=')
+		str := fmt.Sprint(val)
+		if strings.ContainsAny(str, " =\"\t\n") {
+			str = strconv.Quote(str)
+		}
+		sb.WriteString(str)
+	}
+	return sb.String()
+}
+
+// CombinedFormatter Apache combined log format, 格式是固定的, 忽略 fields
+//
+//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /user?id=1 HTTP/1.1" 200 2326 "http://example.com/" "Mozilla/5.0"
+func CombinedFormatter(l *Entry, _ []string) string {
+	uri := l.Path
+	if l.Query != "" {
+		uri += "?" + l.Query
+	}
+	size := "-"
+	if l.Bytes > 0 {
+		size = strconv.Itoa(l.Bytes)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s %q %q`,
+		dash(l.ClientIP), l.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		l.HTTPMethod, uri, l.Proto, l.Status, size, dash(l.Referer), dash(l.UserAgent))
+}
+
+func dash(str string) string {
+	if str == "" {
+		return "-"
+	}
+	return str
 }
 
 /*
@@ -4,7 +4,7 @@ package accesslog
 
 import (
 	"fmt"
-	"frame/web"
+	"my-frame/web"
 	"testing"
 )
 
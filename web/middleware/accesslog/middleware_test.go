@@ -1,24 +1,152 @@
 package accesslog
 
 import (
-	"fmt"
-	"frame/web"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"my-frame/web"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestMiddlewareBuilder(t *testing.T) {
-	builder := MiddlewareBuilder{}
-	mdl := builder.LogFunc(func(log string) {
-		fmt.Println(log)
-	}).Build()
-	server := web.NewHTTPServer(web.ServerWithMiddleware(mdl))
-	server.Post("/a/b/*", func(ctx *web.Context) {
-		fmt.Println("hello, zhangsan")
-	})
-	req, err := http.NewRequest(http.MethodPost, "/a/b/c", nil)
-	if err != nil {
-		t.Fatal(err)
+	traceID, err := trace.TraceIDFromHex("0af7651916cd43dd8448eb211c80319c")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("b7ad6b7169203331")
+	require.NoError(t, err)
+	// 模拟 opentelemetry 的 middleware
+	tracing := func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+			ctx.Req = ctx.Req.WithContext(trace.ContextWithSpanContext(ctx.Req.Context(), sc))
+			next(ctx)
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		builder func(b *MiddlewareBuilder) *MiddlewareBuilder
+		path    string
+		header  http.Header
+		want    []string
+	}{
+		{
+			name: "json",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.Fields(FieldHost, FieldRoute, FieldHTTPMethod, FieldPath, FieldStatus, FieldBytes,
+					FieldClientIP, FieldUserAgent, FieldTraceID, FieldRequestID)
+			},
+			path: "/user/12",
+			header: http.Header{
+				"X-Forwarded-For": []string{"10.0.0.1, 10.0.0.2"},
+				"User-Agent":      []string{"curl/8.0"},
+				"X-Request-Id":    []string{"req-1"},
+			},
+			want: []string{`{"host":"example.com","route":"/user/:id","http_method":"GET","path":"/user/12",` +
+				`"status":200,"bytes":5,"client_ip":"10.0.0.1","user_agent":"curl/8.0",` +
+				`"trace_id":"0af7651916cd43dd8448eb211c80319c","request_id":"req-1"}`},
+		},
+		{
+			name: "logfmt",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.Formatter(LogfmtFormatter).Fields(FieldHTTPMethod, FieldPath, FieldStatus, FieldClientIP, FieldUserAgent, FieldError)
+			},
+			path:   "/error",
+			header: http.Header{"User-Agent": []string{"Mozilla/5.0 (X11)"}},
+			want:   []string{`http_method=GET path=/error status=500 client_ip=192.0.2.1 user_agent="Mozilla/5.0 (X11)" error="db: 连接失败"`},
+		},
+		{
+			name: "combined",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.Formatter(func(l *Entry, fields []string) string {
+					// 时间不固定
+					l.Time = time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600))
+					return CombinedFormatter(l, fields)
+				})
+			},
+			path: "/user/12?name=Tom",
+			header: http.Header{
+				"X-Real-Ip":  []string{"10.0.0.3"},
+				"Referer":    []string{"http://example.com/"},
+				"User-Agent": []string{"curl/8.0"},
+			},
+			want: []string{`10.0.0.3 - - [10/Oct/2000:13:55:36 -0700] "GET /user/12?name=Tom HTTP/1.1" 200 5 "http://example.com/" "curl/8.0"`},
+		},
+		{
+			name: "errors only",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.ErrorsOnly().Fields(FieldPath, FieldStatus)
+			},
+			path: "/user/12",
+		},
+		{
+			name: "errors only with error",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.ErrorsOnly().Fields(FieldPath, FieldStatus)
+			},
+			path: "/error",
+			want: []string{`{"path":"/error","status":500}`},
+		},
+		{
+			name: "slow",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.SlowThreshold(10 * time.Millisecond).Fields(FieldPath)
+			},
+			path: "/slow",
+			want: []string{`{"path":"/slow"}`},
+		},
+		{
+			name: "not slow",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.SlowThreshold(time.Second).Fields(FieldPath)
+			},
+			path: "/slow",
+		},
+		{
+			name: "sample",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.SampleRate(0.0000001).Fields(FieldPath)
+			},
+			path: "/user/12",
+		},
+		{
+			// 出错的请求不受采样的影响
+			name: "sample error",
+			builder: func(b *MiddlewareBuilder) *MiddlewareBuilder {
+				return b.SampleRate(0.0000001).Fields(FieldPath)
+			},
+			path: "/error",
+			want: []string{`{"path":"/error"}`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs []string
+			builder := NewMiddlewareBuilder().LogFunc(func(log string) {
+				logs = append(logs, log)
+			})
+			server := web.NewHTTPServer(web.ServerWithMiddleware(tc.builder(builder).Build(), tracing))
+			server.Get("/user/:id", func(ctx *web.Context) {
+				ctx.RespData = []byte("hello")
+			})
+			server.Get("/error", web.WrapErr(func(ctx *web.Context) error {
+				return errors.New("db: 连接失败")
+			}))
+			server.Get("/slow", func(ctx *web.Context) {
+				time.Sleep(20 * time.Millisecond)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil).WithContext(context.Background())
+			for key, vals := range tc.header {
+				req.Header[key] = vals
+			}
+			server.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tc.want, logs)
+		})
 	}
-	server.ServeHTTP(nil, req)
 }
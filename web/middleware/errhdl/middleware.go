@@ -1,21 +1,79 @@
 package errhdl
 
-import "my-frame/web"
+import (
+	"my-frame/web"
+	"net/http"
+	"strings"
+)
+
+// PageFunc 动态生成错误页面, 可以通过 ctx 拿到请求、响应码和原始的 error
+type PageFunc func(ctx *web.Context) []byte
+
+// PageData 使用模板渲染错误页面的时候, 传给模板的数据
+type PageData struct {
+	Code    int
+	Message string
+	Path    string
+}
 
 type MiddlewareBuilder struct {
 	// 这种设计只能返回固定的值
 	// 不能做到动态的渲染
-	resp map[int][]byte
+	// resp map[int][]byte
+	// 所以改成了 PageFunc, 固定的值也包装成 PageFunc
+	pages map[int]PageFunc
+	// 按照响应码范围注册的, 例如所有的 5xx, 精确匹配的优先
+	ranges []statusRange
+	// 这些前缀下面的请求都是 API 请求, 不替换响应
+	apiPrefixes []string
+}
+
+type statusRange struct {
+	from int
+	to   int
+	page PageFunc
 }
 
 func NewMiddlewareBuilder() *MiddlewareBuilder {
 	return &MiddlewareBuilder{
-		resp: map[int][]byte{},
+		pages: map[int]PageFunc{},
 	}
 }
 
+// AddCode 固定的错误页面
 func (m *MiddlewareBuilder) AddCode(status int, data []byte) *MiddlewareBuilder {
-	m.resp[status] = data
+	return m.AddFunc(status, staticPage(data))
+}
+
+// AddFunc 动态生成错误页面
+func (m *MiddlewareBuilder) AddFunc(status int, fn PageFunc) *MiddlewareBuilder {
+	m.pages[status] = fn
+	return m
+}
+
+// AddTemplate 用模板渲染错误页面, 模板的数据是 PageData
+// 需要 HTTPServer 设置了 TemplateEngine, 没有设置或者渲染失败的时候保留原本的响应
+func (m *MiddlewareBuilder) AddTemplate(status int, tplName string) *MiddlewareBuilder {
+	return m.AddFunc(status, templatePage(tplName))
+}
+
+// AddRangeCode 响应码在 [from, to] 之间的都用这个页面, 例如 AddRangeCode(500, 599, data)
+func (m *MiddlewareBuilder) AddRangeCode(from, to int, data []byte) *MiddlewareBuilder {
+	return m.AddRangeFunc(from, to, staticPage(data))
+}
+
+func (m *MiddlewareBuilder) AddRangeFunc(from, to int, fn PageFunc) *MiddlewareBuilder {
+	m.ranges = append(m.ranges, statusRange{from: from, to: to, page: fn})
+	return m
+}
+
+func (m *MiddlewareBuilder) AddRangeTemplate(from, to int, tplName string) *MiddlewareBuilder {
+	return m.AddRangeFunc(from, to, templatePage(tplName))
+}
+
+// SkipPrefix 这些路径前缀下面的都是 API 请求, 例如 /api, 出错的时候不替换成页面
+func (m *MiddlewareBuilder) SkipPrefix(prefixes ...string) *MiddlewareBuilder {
+	m.apiPrefixes = append(m.apiPrefixes, prefixes...)
 	return m
 }
 
@@ -23,11 +81,84 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			next(ctx)
-			resp, ok := m.resp[ctx.RespStatusCode]
-			if ok {
-				// 篡改结构
-				ctx.RespData = resp
+			page, ok := m.page(ctx.RespStatusCode)
+			if !ok || m.isAPI(ctx) {
+				return
+			}
+			contentType := ctx.RespHeader.Get("Content-Type")
+			data := page(ctx)
+			if data == nil {
+				// 没有替换, 原本的响应和 Content-Type 都保留
+				return
 			}
+			// 原本的 Content-Type 可能是 application/json 之类的, 交给 flashResp 重新判断
+			// 页面自己设置了的(例如模板)就不动
+			if ctx.RespHeader.Get("Content-Type") == contentType {
+				ctx.RespHeader.Del("Content-Type")
+			}
+			// 篡改结构
+			ctx.RespData = data
+		}
+	}
+}
+
+func (m MiddlewareBuilder) page(status int) (PageFunc, bool) {
+	if page, ok := m.pages[status]; ok {
+		return page, true
+	}
+	for _, r := range m.ranges {
+		if status >= r.from && status <= r.to {
+			return r.page, true
+		}
+	}
+	return nil, false
+}
+
+// isAPI 例如异步加载数据的 RESTful 请求, 即便 404 了也不应该返回页面
+// 命中了 SkipPrefix, 或者是 AJAX 请求, 或者 Accept 里面优先的明确不是 HTML, 例如 application/json
+// 没有 Accept 或者是 */* 的时候还是替换
+func (m MiddlewareBuilder) isAPI(ctx *web.Context) bool {
+	path := ctx.Req.URL.Path
+	for _, prefix := range m.apiPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	if ctx.Req.Header.Get("X-Requested-With") == "XMLHttpRequest" {
+		return true
+	}
+	switch web.PreferredMediaType(ctx.Req) {
+	case "", "*/*", "text/*", "text/html", "application/xhtml+xml":
+		return false
+	default:
+		return true
+	}
+}
+
+func staticPage(data []byte) PageFunc {
+	return func(ctx *web.Context) []byte {
+		return data
+	}
+}
+
+func templatePage(tplName string) PageFunc {
+	return func(ctx *web.Context) []byte {
+		status := ctx.RespStatusCode
+		data := PageData{
+			Code:    status,
+			Message: http.StatusText(status),
+			Path:    ctx.Req.URL.Path,
+		}
+		// Render 会修改响应码和 RespData, 要改回来
+		origin := ctx.RespData
+		err := ctx.Render(tplName, data)
+		ctx.RespStatusCode = status
+		if err != nil {
+			// 渲染失败就保留原本的响应
+			ctx.RespData = origin
+			return nil
 		}
+		ctx.SetHeader("Content-Type", "text/html; charset=utf-8")
+		return ctx.RespData
 	}
 }
@@ -1,30 +1,176 @@
-//go:build e2e
-
 package errhdl
 
 import (
-	"frame/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"my-frame/web"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 )
 
-func TestMiddlewareBuilder_Build(t *testing.T) {
-	builder := NewMiddlewareBuilder()
-	builder.AddCode(http.StatusNotFound, []byte(`
-<html>
-	<body>
-		<h1> 页面只要不到阿, 兄弟儿 </h1>
-	</body>
-</html>
+func TestMiddlewareBuilder_Pages(t *testing.T) {
+	tpl, err := template.New("").Parse(`{{define "error"}}<h1>{{.Code}} {{.Message}} {{.Path}}</h1>{{end}}`)
+	require.NoError(t, err)
+
+	builder := NewMiddlewareBuilder().
+		AddCode(http.StatusNotFound, []byte("<html><body>not found</body></html>")).
+		AddTemplate(http.StatusForbidden, "error").
+		AddTemplate(http.StatusUnauthorized, "not exist").
+		AddFunc(http.StatusBadRequest, func(ctx *web.Context) []byte {
+			return []byte("bad request: " + string(ctx.RespData))
+		}).
+		AddRangeTemplate(500, 599, "error").
+		SkipPrefix("/api")
+	server := web.NewHTTPServer(
+		web.ServerWithTemplateEngine(&web.GoTemplateEngine{T: tpl}),
+		web.ServerWithMiddleware(builder.Build()))
+	handler := func(ctx *web.Context) {
+		code, _ := strconv.Atoi(ctx.PathParams["code"])
+		_ = ctx.RespJSON(code, "origin")
+	}
+	server.Get("/code/:code", handler)
+	server.Get("/api/code/:code", handler)
+
+	browser := http.Header{"Accept": []string{"text/html,application/xhtml+xml,*/*;q=0.8"}}
+	testCases := []struct {
+		name     string
+		path     string
+		header   http.Header
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{
+			name:     "static",
+			path:     "/code/404",
+			header:   browser,
+			wantCode: http.StatusNotFound,
+			wantType: "text/html; charset=utf-8",
+			wantBody: "<html><body>not found</body></html>",
+		},
+		{
+			name:     "template",
+			path:     "/code/403",
+			header:   browser,
+			wantCode: http.StatusForbidden,
+			wantType: "text/html; charset=utf-8",
+			wantBody: "<h1>403 Forbidden /code/403</h1>",
+		},
+		{
+			name:     "func",
+			path:     "/code/400",
+			header:   browser,
+			wantCode: http.StatusBadRequest,
+			wantType: "text/plain; charset=utf-8",
+			wantBody: `bad request: "origin"`,
+		},
+		{
+			name:     "range",
+			path:     "/code/503",
+			header:   browser,
+			wantCode: http.StatusServiceUnavailable,
+			wantType: "text/html; charset=utf-8",
+			wantBody: "<h1>503 Service Unavailable /code/503</h1>",
+		},
+		{
+			// 渲染失败保留原本的响应, 包括 Content-Type
+			name:     "template error",
+			path:     "/code/401",
+			header:   browser,
+			wantCode: http.StatusUnauthorized,
+			wantType: "application/json",
+			wantBody: `"origin"`,
+		},
+		{
+			name:     "not registered",
+			path:     "/code/409",
+			header:   browser,
+			wantCode: http.StatusConflict,
+			wantType: "application/json",
+			wantBody: `"origin"`,
+		},
+		{
+			name:     "api prefix",
+			path:     "/api/code/404",
+			header:   browser,
+			wantCode: http.StatusNotFound,
+			wantType: "application/json",
+			wantBody: `"origin"`,
+		},
+		{
+			name:     "accept json",
+			path:     "/code/404",
+			header:   http.Header{"Accept": []string{"application/json, text/plain, */*"}},
+			wantCode: http.StatusNotFound,
+			wantType: "application/json",
+			wantBody: `"origin"`,
+		},
+		{
+			name:     "accept xml",
+			path:     "/code/404",
+			header:   http.Header{"Accept": []string{"application/xml"}},
+			wantCode: http.StatusNotFound,
+			wantType: "application/json",
+			wantBody: `"origin"`,
+		},
+		{
+			// 没有明确要求别的类型, 还是替换
+			name:     "accept any",
+			path:     "/code/404",
+			header:   http.Header{"Accept": []string{"*/*"}},
+			wantCode: http.StatusNotFound,
+			wantType: "text/html; charset=utf-8",
+			wantBody: "<html><body>not found</body></html>",
+		},
+		{
+			name:     "no accept",
+			path:     "/code/404",
+			wantCode: http.StatusNotFound,
+			wantType: "text/html; charset=utf-8",
+			wantBody: "<html><body>not found</body></html>",
+		},
+		{
+			name: "ajax",
+			path: "/code/500",
+			header: http.Header{
+				"Accept":           []string{"text/html"},
+				"X-Requested-With": []string{"XMLHttpRequest"},
+			},
+			wantCode: http.StatusInternalServerError,
+			wantType: "application/json",
+			wantBody: `"origin"`,
+		},
+	}
 
-`)).
-		AddCode(http.StatusBadRequest, []byte(`
-<html>
-	<body>
-		<h1> 页面请求的不对阿, 兄弟儿 </h1>
-	</body>
-</html>
-`))
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			for key, vals := range tc.header {
+				req.Header[key] = vals
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantType, resp.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestMiddlewareBuilder_NoTemplateEngine(t *testing.T) {
+	builder := NewMiddlewareBuilder().AddTemplate(http.StatusNotFound, "error")
 	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
-	server.Start(":8081")
+	server.Get("/user", func(ctx *web.Context) {
+		_ = ctx.RespJSON(http.StatusNotFound, "origin")
+	})
+
+	// 没有设置 TemplateEngine, 保留原本的响应
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	assert.Equal(t, `"origin"`, resp.Body.String())
 }
@@ -1,17 +1,33 @@
 package opentelemetry
 
 import (
+	"fmt"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 	"my-frame/web"
+	"net"
+	"net/http"
+	"strings"
+	"time"
 )
 
 const instrumentationName = "frame/web/middleware/opentelementry"
 
 type MiddlewareBuilder struct {
 	Tracer trace.Tracer
+	// 默认是 otel.GetMeterProvider() 里面的 Meter
+	Meter metric.Meter
+	// 默认是 otel.GetTextMapPropagator()
+	Propagator propagation.TextMapPropagator
+	// SpanNameFormatter 默认是 "GET /user/:id", 没有命中路由的时候是 "HTTP GET"
+	SpanNameFormatter func(ctx *web.Context) string
+	// Filter 返回 false 的请求不记录 trace 和 metrics, 例如健康检查
+	Filter func(ctx *web.Context) bool
 }
 
 //func NewMiddlewareBuilder(tracer trace.Tracer) *MiddlewareBuilder {
@@ -24,43 +40,184 @@ func (m MiddlewareBuilder) Build() web.Middleware {
 	if m.Tracer == nil {
 		m.Tracer = otel.GetTracerProvider().Tracer(instrumentationName)
 	}
+	if m.Meter == nil {
+		m.Meter = otel.GetMeterProvider().Meter(instrumentationName)
+	}
+	if m.Propagator == nil {
+		m.Propagator = otel.GetTextMapPropagator()
+	}
+	if m.SpanNameFormatter == nil {
+		m.SpanNameFormatter = defaultSpanName
+	}
+	ms := newMetrics(m.Meter)
+
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
+			if m.Filter != nil && !m.Filter(ctx) {
+				next(ctx)
+				return
+			}
 
+			start := time.Now()
 			reqCtx := ctx.Req.Context()
 
 			// 尝试和客户端的 trace 结合在一起
-			reqCtx = otel.GetTextMapPropagator().Extract(reqCtx, propagation.HeaderCarrier(ctx.Req.Header))
-
-			reqCtx, span := m.Tracer.Start(reqCtx, "unknown")
-			defer span.End()
+			reqCtx = m.Propagator.Extract(reqCtx, propagation.HeaderCarrier(ctx.Req.Header))
 
-			//defer func() {
-			//	// 这个是只有执行完 next 才可能有值
-			//	span.SetName(ctx.MatchedRoute)
-			//
-			//	// 把响应码加上去
-			//	span.SetAttributes(attribute.Int("http.status", ctx.RespStatusCode))
-			//}()
+			// 这个时候还没有命中路由, 先用 HTTP 方法作为名字
+			reqCtx, span := m.Tracer.Start(reqCtx, "HTTP "+ctx.Req.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(requestAttributes(ctx.Req)...))
 
-			span.SetAttributes(attribute.String("http.method", ctx.Req.Method))
-			span.SetAttributes(attribute.String("http.url", ctx.Req.URL.String()))
-			span.SetAttributes(attribute.String("http.schema", ctx.Req.URL.Scheme))
-			span.SetAttributes(attribute.String("http.host", ctx.Req.Host))
+			// 把 trace 放到响应里面, 客户端可以拿着 trace id 来排查问题
+			if ctx.RespHeader == nil {
+				ctx.RespHeader = http.Header{}
+			}
+			m.Propagator.Inject(reqCtx, propagation.HeaderCarrier(ctx.RespHeader))
 
 			// 可以继续加span
 			ctx.Req = ctx.Req.WithContext(reqCtx)
 			//ctx.Ctx = reqCtx
 
+			ms.activeRequests.Add(reqCtx, 1, metric.WithAttributes(
+				semconv.HTTPMethod(ctx.Req.Method), semconv.HTTPScheme(scheme(ctx.Req))))
+			defer func() {
+				ms.activeRequests.Add(reqCtx, -1, metric.WithAttributes(
+					semconv.HTTPMethod(ctx.Req.Method), semconv.HTTPScheme(scheme(ctx.Req))))
+			}()
+
+			// panic 了也要记录下来, 然后继续往上抛, 交给 recover 的 middleware 处理
+			// 这里已经 recover 了, 所以要在重新 panic 之前结束 span, 不然 SDK 会把 panic 再记录一遍
+			defer func() {
+				if r := recover(); r != nil {
+					span.RecordError(fmt.Errorf("panic: %v", r), trace.WithStackTrace(true))
+					span.SetStatus(codes.Error, fmt.Sprintf("panic: %v", r))
+					m.end(ctx, span, ms, start, http.StatusInternalServerError)
+					span.End()
+					panic(r)
+				}
+			}()
+
 			// 直接调用下一步
 			next(ctx)
 
-			// 这个是只有执行完 next 才可能有值
-			span.SetName(ctx.MatchedRoute)
+			status := ctx.RespStatusCode
+			if status == 0 {
+				status = http.StatusOK
+			}
+			// handler 返回的 error, 例如 web.WrapErr
+			if err := ctx.Err(); err != nil {
+				span.RecordError(err)
+			}
+			// 4xx 是客户端的问题, 按照规范不算服务端 span 的错误
+			if status >= http.StatusInternalServerError {
+				msg := http.StatusText(status)
+				if err := ctx.Err(); err != nil {
+					msg = err.Error()
+				}
+				span.SetStatus(codes.Error, msg)
+			}
+			m.end(ctx, span, ms, start, status)
+			span.End()
+		}
+	}
+}
+
+// end 这些都是只有执行完 next 才可能有值
+func (m MiddlewareBuilder) end(ctx *web.Context, span trace.Span, ms *metrics, start time.Time, status int) {
+	span.SetName(m.SpanNameFormatter(ctx))
+	attrs := []attribute.KeyValue{
+		semconv.HTTPMethod(ctx.Req.Method),
+		semconv.HTTPScheme(scheme(ctx.Req)),
+		semconv.HTTPStatusCode(status),
+	}
+	if ctx.MatchedRoute != "" {
+		attrs = append(attrs, semconv.HTTPRoute(ctx.MatchedRoute))
+	}
+	span.SetAttributes(attrs...)
+	size := ctx.RespSize()
+	span.SetAttributes(semconv.HTTPResponseContentLength(size))
 
-			// 把响应码加上去
-			span.SetAttributes(attribute.Int("http.status", ctx.RespStatusCode))
+	reqCtx := ctx.Req.Context()
+	opt := metric.WithAttributes(attrs...)
+	ms.duration.Record(reqCtx, float64(time.Since(start).Microseconds())/1000, opt)
+	if ctx.Req.ContentLength >= 0 {
+		ms.requestSize.Record(reqCtx, ctx.Req.ContentLength, opt)
+	}
+	ms.responseSize.Record(reqCtx, int64(size), opt)
+}
 
-		}
+func defaultSpanName(ctx *web.Context) string {
+	if ctx.MatchedRoute == "" {
+		return "HTTP " + ctx.Req.Method
+	}
+	return ctx.Req.Method + " " + ctx.MatchedRoute
+}
+
+func requestAttributes(req *http.Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.HTTPMethod(req.Method),
+		semconv.HTTPScheme(scheme(req)),
+		semconv.HTTPTarget(req.URL.RequestURI()),
+		semconv.NetHostName(req.Host),
+	}
+	if ua := req.UserAgent(); ua != "" {
+		attrs = append(attrs, semconv.HTTPUserAgent(ua))
+	}
+	if req.ContentLength > 0 {
+		attrs = append(attrs, semconv.HTTPRequestContentLength(int(req.ContentLength)))
+	}
+	// 经过了代理的时候, X-Forwarded-For 里面第一个才是真正的客户端
+	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+		ip, _, _ := strings.Cut(xff, ",")
+		attrs = append(attrs, semconv.HTTPClientIP(strings.TrimSpace(ip)))
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		attrs = append(attrs, semconv.NetSockPeerAddr(host))
+	}
+	return attrs
+}
+
+func scheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// metrics 和 trace 一起记录的指标, 名字遵循 OpenTelemetry 的 HTTP 语义规范
+type metrics struct {
+	duration       metric.Float64Histogram
+	requestSize    metric.Int64Histogram
+	responseSize   metric.Int64Histogram
+	activeRequests metric.Int64UpDownCounter
+}
+
+func newMetrics(meter metric.Meter) *metrics {
+	duration, err := meter.Float64Histogram("http.server.duration",
+		metric.WithUnit("ms"), metric.WithDescription("HTTP 请求的处理时间"))
+	if err != nil {
+		panic(err)
+	}
+	requestSize, err := meter.Int64Histogram("http.server.request.size",
+		metric.WithUnit("By"), metric.WithDescription("HTTP 请求 body 的大小"))
+	if err != nil {
+		panic(err)
+	}
+	responseSize, err := meter.Int64Histogram("http.server.response.size",
+		metric.WithUnit("By"), metric.WithDescription("HTTP 响应 body 的大小"))
+	if err != nil {
+		panic(err)
+	}
+	activeRequests, err := meter.Int64UpDownCounter("http.server.active_requests",
+		metric.WithUnit("{request}"), metric.WithDescription("正在处理的 HTTP 请求数"))
+	if err != nil {
+		panic(err)
+	}
+	return &metrics{
+		duration:       duration,
+		requestSize:    requestSize,
+		responseSize:   responseSize,
+		activeRequests: activeRequests,
 	}
 }
@@ -1,100 +1,251 @@
-//go:build e2e
-
 package opentelemetry
 
 import (
-	"frame/web"
-	"go.opentelemetry.io/otel"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/jaeger"
-	"go.opentelemetry.io/otel/exporters/zipkin"
-	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.15.0"
-	"log"
-	"os"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"my-frame/web"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
 )
 
-func TestMiddlewareBuilder_Build(t *testing.T) {
-	tracer := otel.GetTracerProvider().Tracer(instrumentationName)
-	builder := MiddlewareBuilder{
-		Tracer: tracer,
+func newTestBuilder() (MiddlewareBuilder, *tracetest.SpanRecorder, sdkmetric.Reader) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	return MiddlewareBuilder{
+		Tracer:     tp.Tracer(instrumentationName),
+		Meter:      mp.Meter(instrumentationName),
+		Propagator: propagation.TraceContext{},
+	}, sr, reader
+}
+
+func TestMiddlewareBuilder_Span(t *testing.T) {
+	testCases := []struct {
+		name    string
+		method  string
+		path    string
+		handler web.HandleFunc
+		header  http.Header
+
+		wantName    string
+		wantStatus  int
+		wantCode    codes.Code
+		wantEvents  int
+		wantAttrs   []attribute.KeyValue
+		wantNoRoute bool
+	}{
+		{
+			name:   "ok",
+			method: http.MethodPost,
+			path:   "/user/12",
+			header: http.Header{
+				"User-Agent":      []string{"test-agent"},
+				"X-Forwarded-For": []string{"10.0.0.1, 10.0.0.2"},
+			},
+			handler: func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusCreated
+				ctx.RespData = []byte("hello")
+			},
+			wantName:   "POST /user/:id",
+			wantStatus: http.StatusCreated,
+			wantCode:   codes.Unset,
+			wantAttrs: []attribute.KeyValue{
+				semconv.HTTPMethod(http.MethodPost),
+				semconv.HTTPRoute("/user/:id"),
+				semconv.HTTPStatusCode(http.StatusCreated),
+				semconv.HTTPTarget("/user/12"),
+				semconv.HTTPUserAgent("test-agent"),
+				semconv.HTTPClientIP("10.0.0.1"),
+				semconv.NetSockPeerAddr("192.0.2.1"),
+				semconv.HTTPRequestContentLength(3),
+				semconv.HTTPResponseContentLength(5),
+			},
+		},
+		{
+			// 4xx 不算服务端的错误
+			name:   "client error",
+			method: http.MethodPost,
+			path:   "/user/12",
+			handler: web.WrapErr(func(ctx *web.Context) error {
+				return web.NewHTTPError(http.StatusBadRequest, "bad", nil)
+			}),
+			wantName:   "POST /user/:id",
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codes.Unset,
+			wantEvents: 1,
+		},
+		{
+			name:   "server error",
+			method: http.MethodPost,
+			path:   "/user/12",
+			handler: web.WrapErr(func(ctx *web.Context) error {
+				return errors.New("db error")
+			}),
+			wantName:   "POST /user/:id",
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   codes.Error,
+			wantEvents: 1,
+		},
+		{
+			name:        "not found",
+			method:      http.MethodGet,
+			path:        "/order",
+			wantName:    "HTTP GET",
+			wantStatus:  http.StatusNotFound,
+			wantCode:    codes.Unset,
+			wantNoRoute: true,
+		},
 	}
-	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
 
-	server.Get("/user", func(ctx *web.Context) {
-		c, span := tracer.Start(ctx.Req.Context(), "first_layer")
-		defer span.End()
-
-		secondC, second := tracer.Start(c, "second_layer")
-		time.Sleep(time.Second)
-		_, third1 := tracer.Start(secondC, "third_layer1")
-		time.Sleep(100 * time.Millisecond)
-		third1.End()
-		_, third2 := tracer.Start(secondC, "third_layer2")
-		time.Sleep(100 * time.Millisecond)
-		third2.End()
-		second.End()
-
-		_, first := tracer.Start(ctx.Req.Context(), "first_layer1")
-		defer first.End()
-
-		ctx.RespJSON(202, User{
-			Name: "zhangsan",
-			Age:  18,
-		})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder, sr, _ := newTestBuilder()
+			server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+			if tc.handler != nil {
+				server.Post("/user/:id", tc.handler)
+			}
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("abc"))
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantStatus, resp.Code)
 
-	})
+			spans := sr.Ended()
+			require.Len(t, spans, 1)
+			span := spans[0]
+			assert.Equal(t, tc.wantName, span.Name())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.Equal(t, tc.wantCode, span.Status().Code)
+			assert.Len(t, span.Events(), tc.wantEvents)
+			assert.Contains(t, span.Attributes(), semconv.HTTPStatusCode(tc.wantStatus))
+			for _, attr := range tc.wantAttrs {
+				assert.Contains(t, span.Attributes(), attr)
+			}
+			if tc.wantNoRoute {
+				for _, attr := range span.Attributes() {
+					assert.NotEqual(t, semconv.HTTPRouteKey, attr.Key)
+				}
+			}
 
-	initZipkin(t)
+			// trace 放到了响应头里面
+			assert.Contains(t, resp.Header().Get("Traceparent"), span.SpanContext().TraceID().String())
+		})
+	}
+}
 
-	server.Start(":8081")
+func TestMiddlewareBuilder_Panic(t *testing.T) {
+	builder, sr, _ := newTestBuilder()
+	mdl := builder.Build()
+	handler := mdl(func(ctx *web.Context) {
+		panic("boom")
+	})
+	ctx := &web.Context{Req: httptest.NewRequest(http.MethodGet, "/user", nil)}
+	assert.PanicsWithValue(t, "boom", func() {
+		handler(ctx)
+	})
 
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "panic: boom", spans[0].Status().Description)
+	assert.Contains(t, spans[0].Attributes(), semconv.HTTPStatusCode(http.StatusInternalServerError))
+	require.Len(t, spans[0].Events(), 1)
+	assert.Equal(t, "exception", spans[0].Events()[0].Name)
 }
 
-type User struct {
-	Name string `json:"name"`
-	Age  int    `json:"age"`
+func TestMiddlewareBuilder_Parent(t *testing.T) {
+	builder, sr, _ := newTestBuilder()
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	var childCtx trace.SpanContext
+	server.Get("/user", func(ctx *web.Context) {
+		// 后面可以继续创建子 span
+		_, span := builder.Tracer.Start(ctx.Req.Context(), "child")
+		childCtx = span.SpanContext()
+		span.End()
+	})
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[1].SpanContext().TraceID().String())
+	assert.Equal(t, "b7ad6b7169203331", spans[1].Parent().SpanID().String())
+	assert.Equal(t, spans[1].SpanContext().TraceID(), childCtx.TraceID())
 }
 
-func initZipkin(t *testing.T) {
-	exporyer, err := zipkin.New(
-		"http://120.46.191.186:9411/api/v2/spans",
-		zipkin.WithLogger(log.New(os.Stderr, "opentelemetry-demo", log.Ldate)),
-	)
-	if err != nil {
-		t.Fatal(err)
+func TestMiddlewareBuilder_Options(t *testing.T) {
+	builder, sr, _ := newTestBuilder()
+	builder.SpanNameFormatter = func(ctx *web.Context) string {
+		return "api " + ctx.MatchedRoute
+	}
+	builder.Filter = func(ctx *web.Context) bool {
+		return ctx.Req.URL.Path != "/health"
 	}
-	batcher := sdktrace.NewBatchSpanProcessor(exporyer)
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithSpanProcessor(batcher),
-		sdktrace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String("opentelemetry-demo"),
-		)),
-	)
-	otel.SetTracerProvider(tp)
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.Get("/health", func(ctx *web.Context) {})
+	server.Get("/user", func(ctx *web.Context) {})
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
 
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "api /user", spans[0].Name())
 }
 
-func initJeager(t *testing.T) {
-	url := "http://localhost:14268/api/traces"
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
-	if err != nil {
-		t.Fatal(err)
+func TestMiddlewareBuilder_Metrics(t *testing.T) {
+	builder, _, reader := newTestBuilder()
+	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
+	server.Post("/user/:id", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusCreated
+		ctx.RespData = []byte("hello")
+	})
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/user/12", strings.NewReader("abc"))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	require.Len(t, rm.ScopeMetrics, 1)
+	got := map[string]metricdata.Aggregation{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		got[m.Name] = m.Data
 	}
-	tp := sdktrace.NewTracerProvider(
-		// Always be syre to batch in production
-		sdktrace.WithBatcher(exp),
-		// Record information about this application in a Resource
-		sdktrace.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String("opentelemetry-demo"),
-			attribute.String("environment", "dev"),
-			attribute.Int64("ID", 1),
-		)),
-	)
-	otel.SetTracerProvider(tp)
+
+	duration := got["http.server.duration"].(metricdata.Histogram[float64])
+	require.Len(t, duration.DataPoints, 1)
+	assert.Equal(t, uint64(2), duration.DataPoints[0].Count)
+	route, ok := duration.DataPoints[0].Attributes.Value(semconv.HTTPRouteKey)
+	assert.True(t, ok)
+	assert.Equal(t, "/user/:id", route.AsString())
+
+	reqSize := got["http.server.request.size"].(metricdata.Histogram[int64])
+	require.Len(t, reqSize.DataPoints, 1)
+	assert.Equal(t, int64(6), reqSize.DataPoints[0].Sum)
+
+	respSize := got["http.server.response.size"].(metricdata.Histogram[int64])
+	require.Len(t, respSize.DataPoints, 1)
+	assert.Equal(t, int64(10), respSize.DataPoints[0].Sum)
+
+	active := got["http.server.active_requests"].(metricdata.Sum[int64])
+	require.Len(t, active.DataPoints, 1)
+	assert.Equal(t, int64(0), active.DataPoints[0].Value)
 }
@@ -1,53 +1,211 @@
 package prometheus
 
 import (
+	"errors"
+	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"my-frame/web"
 	"strconv"
 	"time"
 )
 
+// DefaultBuckets 响应时间的默认分桶, 单位是毫秒
+var DefaultBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// DefaultSizeBuckets 请求和响应大小的默认分桶, 单位是字节, 100B 到 1GB
+var DefaultSizeBuckets = prometheus.ExponentialBuckets(100, 10, 8)
+
 type MiddlewareBuilder struct {
 	Namespace string
 	Subsystem string
 	Name      string
 	Help      string
+
+	// Histogram 为 true 的时候使用 Histogram 统计响应时间, 否则使用 Summary
+	// Summary 的分位数是在每个实例上算好的, 多个实例之间不能聚合
+	// Histogram 可以在 Prometheus 上用 histogram_quantile 聚合多个实例
+	Histogram bool
+	// Histogram 的分桶, 单位是毫秒, 默认是 DefaultBuckets
+	Buckets []float64
+
+	// 统计请求和响应的大小, 指标名字是 Name 加上 _request_size_bytes 和 _response_size_bytes
+	SizeMetrics bool
+	SizeBuckets []float64
+
+	// 统计正在处理的请求数, 指标名字是 Name 加上 _in_flight_requests
+	InFlight bool
+
+	// 默认是 prometheus.DefaultRegisterer
+	// 测试或者一个进程里面有多个 HTTPServer 的时候, 可以传入自己的 prometheus.Registry
+	// 使用 MetricsHandler 暴露指标的时候, 传入同一个 Registry
+	Registerer prometheus.Registerer
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
-	vector := prometheus.NewSummaryVec(prometheus.SummaryOpts{
-		Name:      m.Name,
-		Subsystem: m.Subsystem,
-		Namespace: m.Namespace,
-		Help:      m.Help,
-		Objectives: map[float64]float64{
-			0.5:   0.01,
-			0.75:  0.01,
-			0.90:  0.01,
-			0.99:  0.001,
-			0.999: 0.0001,
-		},
-	}, []string{"pattern", "method", "status"})
-
-	// 一个vector 只能注册一次
-	prometheus.MustRegister(vector)
+	if m.Registerer == nil {
+		m.Registerer = prometheus.DefaultRegisterer
+	}
+	labels := []string{"pattern", "method", "status"}
+
+	var latency prometheus.ObserverVec
+	if m.Histogram {
+		buckets := m.Buckets
+		if len(buckets) == 0 {
+			buckets = DefaultBuckets
+		}
+		latency = registerHistogram(m.Registerer, prometheus.HistogramOpts{
+			Name:      m.Name,
+			Subsystem: m.Subsystem,
+			Namespace: m.Namespace,
+			Help:      m.Help,
+			Buckets:   buckets,
+		}, labels)
+	} else {
+		latency = register(m.Registerer, prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Name:      m.Name,
+			Subsystem: m.Subsystem,
+			Namespace: m.Namespace,
+			Help:      m.Help,
+			Objectives: map[float64]float64{
+				0.5:   0.01,
+				0.75:  0.01,
+				0.90:  0.01,
+				0.99:  0.001,
+				0.999: 0.0001,
+			},
+		}, labels))
+	}
+
+	var reqSize, respSize *prometheus.HistogramVec
+	if m.SizeMetrics {
+		buckets := m.SizeBuckets
+		if len(buckets) == 0 {
+			buckets = DefaultSizeBuckets
+		}
+		reqSize = registerHistogram(m.Registerer, prometheus.HistogramOpts{
+			Name:      m.Name + "_request_size_bytes",
+			Subsystem: m.Subsystem,
+			Namespace: m.Namespace,
+			Help:      "HTTP 请求 body 的大小",
+			Buckets:   buckets,
+		}, labels)
+		respSize = registerHistogram(m.Registerer, prometheus.HistogramOpts{
+			Name:      m.Name + "_response_size_bytes",
+			Subsystem: m.Subsystem,
+			Namespace: m.Namespace,
+			Help:      "HTTP 响应 body 的大小",
+			Buckets:   buckets,
+		}, labels)
+	}
+
+	var inFlight prometheus.Gauge
+	if m.InFlight {
+		inFlight = register(m.Registerer, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:      m.Name + "_in_flight_requests",
+			Subsystem: m.Subsystem,
+			Namespace: m.Namespace,
+			Help:      "正在处理的 HTTP 请求数",
+		}))
+	}
 
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			startTime := time.Now()
+			if inFlight != nil {
+				inFlight.Inc()
+			}
 			defer func() {
+				if inFlight != nil {
+					inFlight.Dec()
+				}
 				// Observe响应时间
-				duration := time.Now().Sub(startTime).Milliseconds()
+				duration := float64(time.Since(startTime).Microseconds()) / 1000
 				// 路由
 				pattem := ctx.MatchedRoute
 				if pattem == "" {
 					pattem = "unknow"
 				}
-
-				vector.WithLabelValues(pattem, ctx.Req.Method, strconv.Itoa(ctx.RespStatusCode)).Observe(float64(duration))
+				status := ctx.RespStatusCode
+				if status == 0 {
+					status = 200
+				}
+				lvs := []string{pattem, ctx.Req.Method, strconv.Itoa(status)}
+				latency.WithLabelValues(lvs...).Observe(duration)
+				if reqSize != nil {
+					// 不知道长度的请求, 例如 chunked, 不统计
+					if ctx.Req.ContentLength >= 0 {
+						reqSize.WithLabelValues(lvs...).Observe(float64(ctx.Req.ContentLength))
+					}
+					respSize.WithLabelValues(lvs...).Observe(float64(ctx.RespSize()))
+				}
 			}()
 			next(ctx)
 
 		}
 	}
 }
+
+// register 一个指标只能注册一次, 重复注册的时候复用已经注册的那个
+// 这样 Build 多次也不会 panic, 但是类型或者 Help 不一样的时候还是会 panic
+func register[T prometheus.Collector](reg prometheus.Registerer, c T) T {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
+
+// histogramVec 记住注册时候的分桶
+// prometheus 的 Desc 里面没有分桶, 重复注册的时候从 AlreadyRegisteredError 里面拿出已经注册的比较
+type histogramVec struct {
+	*prometheus.HistogramVec
+	buckets []float64
+}
+
+// registerHistogram 复用已经注册的 Histogram 的时候, 分桶必须一致
+// 不然新的分桶不会生效, 所以直接 panic
+func registerHistogram(reg prometheus.Registerer, opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	hist := register(reg, &histogramVec{
+		HistogramVec: prometheus.NewHistogramVec(opts, labels),
+		buckets:      opts.Buckets,
+	})
+	if !equalBuckets(hist.buckets, opts.Buckets) {
+		panic(fmt.Errorf("web: 指标 %s 已经使用分桶 %v 注册过了, 不能再使用分桶 %v",
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), hist.buckets, opts.Buckets))
+	}
+	return hist.HistogramVec
+}
+
+func equalBuckets(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MetricsHandler 暴露 gatherer 里面的指标给 Prometheus 采集
+// gatherer 为 nil 的时候使用 prometheus.DefaultGatherer
+// 和 MiddlewareBuilder 用同一个 *prometheus.Registry 的时候, 只会暴露它里面的指标
+//
+//	reg := prometheus.NewRegistry()
+//	mdl := MiddlewareBuilder{Registerer: reg, ...}.Build()
+//	h := web.NewHTTPServer(web.ServerWithMiddleware(mdl))
+//	h.Get("/metrics", MetricsHandler(reg), authMdl)
+func MetricsHandler(gatherer prometheus.Gatherer) web.HandleFunc {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	return web.WrapHandler(promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+}
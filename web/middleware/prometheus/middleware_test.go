@@ -1,46 +1,153 @@
-//go:build e2e
-
 package prometheus
 
 import (
-	"frame/web"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"math/rand"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"my-frame/web"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
-	"time"
 )
 
-func TestMiddlewareBuilder_Build(t *testing.T) {
+func TestMiddlewareBuilder_Histogram(t *testing.T) {
+	reg := prometheus.NewRegistry()
 	builder := MiddlewareBuilder{
-		Namespace: "zhangsan_test",
-		Subsystem: "web",
-		Name:      "http_response",
-		Help:      "Test",
+		Namespace:   "zhangsan_test",
+		Subsystem:   "web",
+		Name:        "http_response",
+		Help:        "Test",
+		Histogram:   true,
+		Buckets:     []float64{1, 10, 100},
+		SizeMetrics: true,
+		SizeBuckets: []float64{10, 100},
+		InFlight:    true,
+		Registerer:  reg,
 	}
 	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
-	server.Get("/user", func(ctx *web.Context) {
-		val := rand.Intn(1000) + 1
-		time.Sleep(time.Duration(val) + time.Millisecond)
-		ctx.RespJSON(202, User{
-			Name: "李四",
-			Age:  28,
-			Addr: "上海市",
-		})
 
+	server.Post("/user/:id", func(ctx *web.Context) {
+		ctx.RespStatusCode = http.StatusCreated
+		ctx.RespData = []byte("hello")
 	})
+	server.Get("/metrics", MetricsHandler(reg))
 
-	// grafana
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		http.ListenAndServe(":8082", nil)
-	}()
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/user/12", strings.NewReader("abc"))
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
 
-	server.Start(":8081")
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	body := resp.Body.String()
+	for _, line := range []string{
+		`zhangsan_test_web_http_response_bucket{method="POST",pattern="/user/:id",status="201",le="+Inf"} 2`,
+		`zhangsan_test_web_http_response_count{method="POST",pattern="/user/:id",status="201"} 2`,
+		`zhangsan_test_web_http_response_request_size_bytes_bucket{method="POST",pattern="/user/:id",status="201",le="10"} 2`,
+		`zhangsan_test_web_http_response_request_size_bytes_sum{method="POST",pattern="/user/:id",status="201"} 6`,
+		`zhangsan_test_web_http_response_response_size_bytes_sum{method="POST",pattern="/user/:id",status="201"} 10`,
+		// 请求 /metrics 自己
+		`zhangsan_test_web_http_response_in_flight_requests 1`,
+	} {
+		assert.Contains(t, body, line)
+	}
 }
 
-type User struct {
-	Name string `json:"name"`
-	Age  int    `json:"age"`
-	Addr string `json:"addr"`
+func TestMiddlewareBuilder_Summary(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	builder := MiddlewareBuilder{
+		Namespace:  "zhangsan_test",
+		Subsystem:  "web",
+		Name:       "http_response",
+		Help:       "Test",
+		Registerer: reg,
+	}
+	// 多次 Build 不会 panic, 用的是同一个指标
+	mdl := builder.Build()
+	assert.NotPanics(t, func() {
+		builder.Build()
+	})
+	server := web.NewHTTPServer(web.ServerWithMiddleware(mdl))
+	server.Get("/user", func(ctx *web.Context) {})
+
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/user", nil))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order", nil))
+
+	// 耗时不固定, 只比较次数
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, mfs, 1)
+	var lines []string
+	for _, metric := range mfs[0].GetMetric() {
+		labels := map[string]string{}
+		for _, lp := range metric.GetLabel() {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		lines = append(lines, labels["pattern"]+" "+labels["status"])
+		assert.Equal(t, uint64(1), metric.GetSummary().GetSampleCount())
+	}
+	assert.ElementsMatch(t, []string{"/user 200", "unknow 404"}, lines)
+	assert.Equal(t, 2, testutil.CollectAndCount(reg, "zhangsan_test_web_http_response"))
+}
+
+func TestMiddlewareBuilder_Reuse(t *testing.T) {
+	testCases := []struct {
+		name      string
+		first     MiddlewareBuilder
+		second    MiddlewareBuilder
+		wantPanic bool
+	}{
+		{
+			name:   "same buckets",
+			first:  MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true, Buckets: []float64{1, 10}},
+			second: MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true, Buckets: []float64{1, 10}},
+		},
+		{
+			// 默认的分桶
+			name:   "default buckets",
+			first:  MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true},
+			second: MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true},
+		},
+		{
+			name:      "different buckets",
+			first:     MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true, Buckets: []float64{1, 10}},
+			second:    MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true, Buckets: []float64{1, 100}},
+			wantPanic: true,
+		},
+		{
+			name:      "different size buckets",
+			first:     MiddlewareBuilder{Name: "http_response", Help: "Test", SizeMetrics: true, SizeBuckets: []float64{10}},
+			second:    MiddlewareBuilder{Name: "http_response", Help: "Test", SizeMetrics: true, SizeBuckets: []float64{100}},
+			wantPanic: true,
+		},
+		{
+			name:      "summary to histogram",
+			first:     MiddlewareBuilder{Name: "http_response", Help: "Test"},
+			second:    MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true},
+			wantPanic: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			tc.first.Registerer = reg
+			tc.second.Registerer = reg
+			tc.first.Build()
+			if tc.wantPanic {
+				assert.Panics(t, func() { tc.second.Build() })
+				return
+			}
+			assert.NotPanics(t, func() { tc.second.Build() })
+		})
+	}
+
+	// 不同的 Registerer 互不影响
+	assert.NotPanics(t, func() {
+		MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true, Buckets: []float64{1}, Registerer: prometheus.NewRegistry()}.Build()
+		MiddlewareBuilder{Name: "http_response", Help: "Test", Histogram: true, Buckets: []float64{2}, Registerer: prometheus.NewRegistry()}.Build()
+	})
 }
@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	cache "github.com/patrickmn/go-cache"
+	"math"
+	"my-frame/web/middleware/ratelimit"
+	"sync"
+	"time"
+)
+
+// Store 单机的限流, 多个实例之间不共享额度, 需要共享的话用 redis.Store
+type Store struct {
+	mutex sync.Mutex
+	// 每个 key 的状态, 额度完全恢复之后就过期了
+	states *cache.Cache
+	// 测试的时候替换掉
+	now func() time.Time
+}
+
+func NewStore() *Store {
+	return &Store{
+		states: cache.New(time.Minute, time.Minute),
+		now:    time.Now,
+	}
+}
+
+func (s *Store) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now().UnixNano()
+	switch limit.Algorithm {
+	case ratelimit.TokenBucket:
+		return s.tokenBucket(key, limit, now), nil
+	case ratelimit.FixedWindow:
+		return s.fixedWindow(key, limit, now), nil
+	case ratelimit.SlidingWindow:
+		return s.slidingWindow(key, limit, now), nil
+	}
+	return ratelimit.Result{}, fmt.Errorf("ratelimit: 不支持的限流算法 %d", limit.Algorithm)
+}
+
+type bucket struct {
+	tokens float64
+	last   int64
+}
+
+func (s *Store) tokenBucket(key string, limit ratelimit.Limit, now int64) ratelimit.Result {
+	capacity := float64(limit.Capacity())
+	// 每纳秒放多少个令牌
+	speed := float64(limit.Rate) / float64(limit.Period)
+	b, ok := s.get(key).(*bucket)
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+	}
+	if now > b.last {
+		b.tokens = math.Min(capacity, b.tokens+float64(now-b.last)*speed)
+		b.last = now
+	}
+
+	res := ratelimit.Result{Limit: limit.Capacity()}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / speed))
+	}
+	res.Remaining = int(b.tokens)
+	res.ResetAfter = time.Duration(math.Ceil((capacity - b.tokens) / speed))
+	s.set(key, b, res.ResetAfter)
+	return res
+}
+
+type window struct {
+	start int64
+	count int
+}
+
+func (s *Store) fixedWindow(key string, limit ratelimit.Limit, now int64) ratelimit.Result {
+	period := int64(limit.Period)
+	start := now - now%period
+	w, ok := s.get(key).(*window)
+	if !ok || w.start != start {
+		w = &window{start: start}
+	}
+
+	res := ratelimit.Result{Limit: limit.Rate, ResetAfter: time.Duration(start + period - now)}
+	if w.count < limit.Rate {
+		w.count++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = res.ResetAfter
+	}
+	res.Remaining = limit.Rate - w.count
+	s.set(key, w, res.ResetAfter)
+	return res
+}
+
+type slidingWindow struct {
+	start int64
+	prev  int
+	curr  int
+}
+
+func (s *Store) slidingWindow(key string, limit ratelimit.Limit, now int64) ratelimit.Result {
+	period := int64(limit.Period)
+	start := now - now%period
+	w, ok := s.get(key).(*slidingWindow)
+	if !ok {
+		w = &slidingWindow{start: start}
+	}
+	if w.start != start {
+		// 中间隔了一个窗口以上的话, 上一个窗口就没有请求
+		if w.start == start-period {
+			w.prev = w.curr
+		} else {
+			w.prev = 0
+		}
+		w.curr = 0
+		w.start = start
+	}
+
+	rate := float64(limit.Rate)
+	elapsed := now - start
+	// 上一个窗口还有多少落在滑动窗口里面
+	weight := 1 - float64(elapsed)/float64(period)
+	res := ratelimit.Result{Limit: limit.Rate}
+	if float64(w.prev)*weight+float64(w.curr)+1 <= rate {
+		w.curr++
+		res.Allowed = true
+	} else if w.curr+1 > limit.Rate {
+		// 当前窗口已经满了, 要等到下一个窗口, 并且当前窗口的权重降下来
+		res.RetryAfter = time.Duration(period - elapsed +
+			int64(math.Ceil(float64(period)*(1-(rate-1)/float64(w.curr)))))
+	} else {
+		// 等上一个窗口的权重降下来
+		res.RetryAfter = time.Duration(int64(math.Ceil(float64(period)*(1-(rate-1-float64(w.curr))/float64(w.prev)))) - elapsed)
+	}
+	res.Remaining = int(math.Max(0, math.Floor(rate-float64(w.prev)*weight-float64(w.curr))))
+	switch {
+	case w.curr > 0:
+		res.ResetAfter = time.Duration(2*period - elapsed)
+	case w.prev > 0:
+		res.ResetAfter = time.Duration(period - elapsed)
+	}
+	s.set(key, w, time.Duration(2*period-elapsed))
+	return res
+}
+
+func (s *Store) get(key string) any {
+	val, _ := s.states.Get(key)
+	return val
+}
+
+// set 额度完全恢复之后状态和新的 key 一样, 直接删掉
+func (s *Store) set(key string, val any, expiration time.Duration) {
+	if expiration <= 0 {
+		s.states.Delete(key)
+		return
+	}
+	s.states.Set(key, val, expiration)
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"my-frame/web"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// KeyFunc 决定按照什么限流, 返回空字符串代表这个请求不限流
+type KeyFunc func(ctx *web.Context) string
+
+// IPKey 按照客户端的 IP 限流, 用的是 RemoteAddr
+// X-Forwarded-For 可以被客户端伪造, 所以默认不用
+// 部署在可信的代理后面的话, 可以用 HeaderKey("X-Real-Ip")
+func IPKey(ctx *web.Context) string {
+	host, _, err := net.SplitHostPort(ctx.Req.RemoteAddr)
+	if err != nil {
+		return ctx.Req.RemoteAddr
+	}
+	return host
+}
+
+// RouteKey 按照路由限流, 例如 "GET /user/:id", 所有的客户端共享额度
+// 注册在 HTTPServer 上的时候还没有命中路由, 只能用请求的路径, 所以一般注册在路由上
+func RouteKey(ctx *web.Context) string {
+	if ctx.MatchedRoute != "" {
+		return ctx.Req.Method + " " + ctx.MatchedRoute
+	}
+	return ctx.Req.Method + " " + ctx.Req.URL.Path
+}
+
+// HeaderKey 按照某个头部限流, 例如 API Key, 没有这个头部的请求不限流
+func HeaderKey(header string) KeyFunc {
+	return func(ctx *web.Context) string {
+		return ctx.Req.Header.Get(header)
+	}
+}
+
+type MiddlewareBuilder struct {
+	store   Store
+	limit   Limit
+	keyFunc KeyFunc
+	// Store 出错的时候返回 500, 默认是放行
+	failClosed bool
+	logFunc    func(log string)
+}
+
+func NewMiddlewareBuilder(store Store, limit Limit) *MiddlewareBuilder {
+	return &MiddlewareBuilder{
+		store:   store,
+		limit:   limit,
+		keyFunc: IPKey,
+	}
+}
+
+// KeyFunc 默认是 IPKey
+func (m *MiddlewareBuilder) KeyFunc(fn KeyFunc) *MiddlewareBuilder {
+	m.keyFunc = fn
+	return m
+}
+
+// FailClosed Store 出错的时候拒绝请求, 例如 Redis 不可用
+// 默认是放行, 限流不应该影响正常的业务
+func (m *MiddlewareBuilder) FailClosed() *MiddlewareBuilder {
+	m.failClosed = true
+	return m
+}
+
+// LogFunc Store 出错的时候输出日志, 默认用 log
+func (m *MiddlewareBuilder) LogFunc(fn func(log string)) *MiddlewareBuilder {
+	m.logFunc = fn
+	return m
+}
+
+func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.limit.Rate <= 0 || m.limit.Period <= 0 {
+		panic("ratelimit: Rate 和 Period 必须大于 0")
+	}
+	// Redis 的脚本里面时间的单位是毫秒, 不足 1ms 的会变成 0
+	if m.limit.Period < time.Millisecond {
+		panic("ratelimit: Period 不能小于 1ms")
+	}
+	if m.logFunc == nil {
+		m.logFunc = func(l string) {
+			log.Println(l)
+		}
+	}
+	return func(next web.HandleFunc) web.HandleFunc {
+		return func(ctx *web.Context) {
+			key := m.keyFunc(ctx)
+			if key == "" {
+				next(ctx)
+				return
+			}
+			res, err := m.store.Allow(ctx.Req.Context(), key, m.limit)
+			if err != nil {
+				if m.failClosed {
+					ctx.Error(err)
+					return
+				}
+				m.logFunc(fmt.Sprintf("ratelimit: 限流失败, 直接放行: %v", err))
+				next(ctx)
+				return
+			}
+
+			ctx.SetHeader("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			ctx.SetHeader("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			ctx.SetHeader("X-RateLimit-Reset", seconds(res.ResetAfter))
+			if !res.Allowed {
+				ctx.SetHeader("Retry-After", seconds(res.RetryAfter))
+				ctx.Error(web.NewHTTPError(http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), nil))
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// seconds 头部里面的时间都是秒, 向上取整, 避免客户端太早重试
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"my-frame/web"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockStore 每个 key 只允许 rate 个请求
+type mockStore struct {
+	counts map[string]int
+	err    error
+}
+
+func (s *mockStore) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if s.err != nil {
+		return Result{}, s.err
+	}
+	if s.counts == nil {
+		s.counts = map[string]int{}
+	}
+	res := Result{Limit: limit.Rate, ResetAfter: 1500 * time.Millisecond}
+	if s.counts[key] < limit.Rate {
+		s.counts[key]++
+		res.Allowed = true
+	} else {
+		res.RetryAfter = 200 * time.Millisecond
+	}
+	res.Remaining = limit.Rate - s.counts[key]
+	return res, nil
+}
+
+func TestMiddlewareBuilder_Build(t *testing.T) {
+	limit := Limit{Algorithm: FixedWindow, Rate: 1, Period: time.Second}
+	testCases := []struct {
+		name    string
+		builder func(store Store) *MiddlewareBuilder
+		req     func() *http.Request
+		store   *mockStore
+
+		wantCode   int
+		wantHeader http.Header
+		wantKeys   []string
+	}{
+		{
+			name: "allowed",
+			builder: func(store Store) *MiddlewareBuilder {
+				return NewMiddlewareBuilder(store, limit)
+			},
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/12", nil)
+			},
+			store:    &mockStore{},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"X-Ratelimit-Limit":     []string{"1"},
+				"X-Ratelimit-Remaining": []string{"0"},
+				"X-Ratelimit-Reset":     []string{"2"},
+			},
+			wantKeys: []string{"192.0.2.1"},
+		},
+		{
+			name: "rejected",
+			builder: func(store Store) *MiddlewareBuilder {
+				return NewMiddlewareBuilder(store, limit)
+			},
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/12", nil)
+			},
+			store:    &mockStore{counts: map[string]int{"192.0.2.1": 1}},
+			wantCode: http.StatusTooManyRequests,
+			wantHeader: http.Header{
+				"X-Ratelimit-Limit":     []string{"1"},
+				"X-Ratelimit-Remaining": []string{"0"},
+				"X-Ratelimit-Reset":     []string{"2"},
+				"Retry-After":           []string{"1"},
+			},
+		},
+		{
+			name: "route key",
+			builder: func(store Store) *MiddlewareBuilder {
+				return NewMiddlewareBuilder(store, limit).KeyFunc(RouteKey)
+			},
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/12", nil)
+			},
+			store:    &mockStore{},
+			wantCode: http.StatusOK,
+			wantKeys: []string{"GET /user/:id"},
+		},
+		{
+			name: "header key",
+			builder: func(store Store) *MiddlewareBuilder {
+				return NewMiddlewareBuilder(store, limit).KeyFunc(HeaderKey("X-Api-Key"))
+			},
+			req: func() *http.Request {
+				req := httptest.NewRequest(http.MethodGet, "/user/12", nil)
+				req.Header.Set("X-Api-Key", "abc")
+				return req
+			},
+			store:    &mockStore{},
+			wantCode: http.StatusOK,
+			wantKeys: []string{"abc"},
+		},
+		{
+			// 没有 key 的请求不限流
+			name: "empty key",
+			builder: func(store Store) *MiddlewareBuilder {
+				return NewMiddlewareBuilder(store, limit).KeyFunc(HeaderKey("X-Api-Key"))
+			},
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/12", nil)
+			},
+			store:      &mockStore{},
+			wantCode:   http.StatusOK,
+			wantHeader: http.Header{},
+		},
+		{
+			name: "fail open",
+			builder: func(store Store) *MiddlewareBuilder {
+				return NewMiddlewareBuilder(store, limit).LogFunc(func(log string) {})
+			},
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/12", nil)
+			},
+			store:      &mockStore{err: errors.New("redis 挂了")},
+			wantCode:   http.StatusOK,
+			wantHeader: http.Header{},
+		},
+		{
+			name: "fail closed",
+			builder: func(store Store) *MiddlewareBuilder {
+				return NewMiddlewareBuilder(store, limit).FailClosed()
+			},
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/user/12", nil)
+			},
+			store:    &mockStore{err: errors.New("redis 挂了")},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := web.NewHTTPServer()
+			// 注册在路由上, 这样 RouteKey 能拿到命中的路由
+			server.Get("/user/:id", func(ctx *web.Context) {
+				ctx.RespStatusCode = http.StatusOK
+			}, tc.builder(tc.store).Build())
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, tc.req())
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			for k := range tc.wantHeader {
+				assert.Equal(t, tc.wantHeader.Get(k), resp.Header().Get(k), k)
+			}
+			if tc.wantHeader != nil && len(tc.wantHeader) == 0 {
+				assert.Empty(t, resp.Header().Get("X-Ratelimit-Limit"))
+			}
+			for _, key := range tc.wantKeys {
+				assert.Equal(t, 1, tc.store.counts[key])
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_InvalidLimit(t *testing.T) {
+	assert.PanicsWithValue(t, "ratelimit: Rate 和 Period 必须大于 0", func() {
+		NewMiddlewareBuilder(&mockStore{}, Limit{Rate: 1}).Build()
+	})
+	assert.PanicsWithValue(t, "ratelimit: Period 不能小于 1ms", func() {
+		NewMiddlewareBuilder(&mockStore{}, Limit{Rate: 1, Period: time.Microsecond}).Build()
+	})
+}
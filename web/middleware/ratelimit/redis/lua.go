@@ -0,0 +1,124 @@
+package redis
+
+const luaTokenBucket = `
+-- 令牌桶
+-- ARGV: 容量, 每个周期放的令牌数, 周期(毫秒), 当前时间(毫秒)
+-- 返回: 是否允许, 剩余令牌, 多久之后可以重试, 多久之后桶是满的
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+-- 每毫秒放多少个令牌
+local speed = rate / period
+
+local state = redis.call("hmget", KEYS[1], "tokens", "last")
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil then
+    tokens = capacity
+    last = now
+end
+if now > last then
+    tokens = math.min(capacity, tokens + (now - last) * speed)
+    last = now
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+    tokens = tokens - 1
+    allowed = 1
+else
+    retry = math.ceil((1 - tokens) / speed)
+end
+local reset = math.ceil((capacity - tokens) / speed)
+
+if reset > 0 then
+    -- 令牌是小数, 要保留精度
+    redis.call("hset", KEYS[1], "tokens", tostring(tokens), "last", last)
+    redis.call("pexpire", KEYS[1], reset)
+else
+    redis.call("del", KEYS[1])
+end
+return { allowed, math.floor(tokens), retry, reset }
+`
+
+const luaFixedWindow = `
+-- 固定窗口
+-- ARGV: 容量(不用), 每个周期的请求数, 周期(毫秒), 当前时间(毫秒)
+-- 返回: 是否允许, 剩余请求数, 多久之后可以重试, 多久之后进入下一个窗口
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local start = now - now % period
+
+local state = redis.call("hmget", KEYS[1], "start", "count")
+local count = 0
+if tonumber(state[1]) == start then
+    count = tonumber(state[2])
+end
+
+local reset = start + period - now
+local allowed = 0
+local retry = reset
+if count < rate then
+    count = count + 1
+    allowed = 1
+    retry = 0
+    redis.call("hset", KEYS[1], "start", start, "count", count)
+    redis.call("pexpire", KEYS[1], reset)
+end
+return { allowed, rate - count, retry, reset }
+`
+
+const luaSlidingWindow = `
+-- 滑动窗口, 用上一个窗口的计数按照时间加权估算
+-- ARGV: 容量(不用), 每个周期的请求数, 周期(毫秒), 当前时间(毫秒)
+-- 返回: 是否允许, 剩余请求数, 多久之后可以重试, 多久之后计数归零
+local rate = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local start = now - now % period
+
+local state = redis.call("hmget", KEYS[1], "start", "prev", "curr")
+local last = tonumber(state[1])
+local prev = tonumber(state[2]) or 0
+local curr = tonumber(state[3]) or 0
+if last ~= start then
+    -- 中间隔了一个窗口以上的话, 上一个窗口就没有请求
+    if last == start - period then
+        prev = curr
+    else
+        prev = 0
+    end
+    curr = 0
+end
+
+local elapsed = now - start
+-- 上一个窗口还有多少落在滑动窗口里面
+local weight = 1 - elapsed / period
+local allowed = 0
+local retry = 0
+if prev * weight + curr + 1 <= rate then
+    curr = curr + 1
+    allowed = 1
+elseif curr + 1 > rate then
+    -- 当前窗口已经满了, 要等到下一个窗口, 并且当前窗口的权重降下来
+    retry = period - elapsed + math.ceil(period * (1 - (rate - 1) / curr))
+else
+    -- 等上一个窗口的权重降下来
+    retry = math.ceil(period * (1 - (rate - 1 - curr) / prev)) - elapsed
+end
+
+local remaining = math.max(0, math.floor(rate - prev * weight - curr))
+local reset = 0
+if curr > 0 then
+    reset = 2 * period - elapsed
+elseif prev > 0 then
+    reset = period - elapsed
+end
+
+redis.call("hset", KEYS[1], "start", start, "prev", prev, "curr", curr)
+redis.call("pexpire", KEYS[1], 2 * period - elapsed)
+return { allowed, remaining, retry, reset }
+`
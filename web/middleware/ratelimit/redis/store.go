@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"my-frame/web/middleware/ratelimit"
+	"time"
+)
+
+// Script 会先用 EVALSHA, 脚本不存在的时候再用 EVAL
+var scripts = map[ratelimit.Algorithm]*redis.Script{
+	ratelimit.TokenBucket:   redis.NewScript(luaTokenBucket),
+	ratelimit.FixedWindow:   redis.NewScript(luaFixedWindow),
+	ratelimit.SlidingWindow: redis.NewScript(luaSlidingWindow),
+}
+
+type StoreOption func(store *Store)
+
+// Store 多个实例共享额度, 每一次判断都是一个 Lua 脚本, 保证原子性
+// 每个 key 对应一个 hash, 额度完全恢复之后就过期了
+type Store struct {
+	prefix string
+	client redis.Cmdable
+	// 用调用方的时间而不是 Redis 的 TIME, 测试的时候可以替换掉
+	// 多个实例之间的时钟误差会影响限流的精度
+	now func() time.Time
+}
+
+func NewStore(client redis.Cmdable, opts ...StoreOption) *Store {
+	res := &Store{
+		prefix: "ratelimit",
+		client: client,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+func StoreWithPrefix(prefix string) StoreOption {
+	return func(store *Store) {
+		store.prefix = prefix
+	}
+}
+
+func (s *Store) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	script, ok := scripts[limit.Algorithm]
+	if !ok {
+		return ratelimit.Result{}, fmt.Errorf("ratelimit: 不支持的限流算法 %d", limit.Algorithm)
+	}
+	// 脚本里面的时间都是毫秒, 不足 1ms 的 Period 会在脚本里面除以 0
+	if limit.Period < time.Millisecond {
+		return ratelimit.Result{}, fmt.Errorf("ratelimit: Period 不能小于 1ms, 实际是 %s", limit.Period)
+	}
+	vals, err := script.Run(ctx, s.client, []string{s.key(key)},
+		limit.Capacity(), limit.Rate, limit.Period.Milliseconds(), s.now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	if len(vals) != 4 {
+		return ratelimit.Result{}, fmt.Errorf("ratelimit: 脚本返回了 %d 个值", len(vals))
+	}
+	res := ratelimit.Result{
+		Allowed:    vals[0] == 1,
+		Limit:      limit.Rate,
+		Remaining:  int(vals[1]),
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+		ResetAfter: time.Duration(vals[3]) * time.Millisecond,
+	}
+	if limit.Algorithm == ratelimit.TokenBucket {
+		res.Limit = limit.Capacity()
+	}
+	return res, nil
+}
+
+func (s *Store) key(key string) string {
+	return fmt.Sprintf("%s:%s", s.prefix, key)
+}
@@ -0,0 +1,156 @@
+package redis
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"my-frame/web/middleware/ratelimit"
+	"testing"
+	"time"
+)
+
+type step struct {
+	// 和上一步相比过了多久
+	advance time.Duration
+	want    ratelimit.Result
+}
+
+func TestStore_Allow(t *testing.T) {
+	testCases := []struct {
+		name  string
+		limit ratelimit.Limit
+		steps []step
+	}{
+		{
+			name:  "token bucket",
+			limit: ratelimit.Limit{Algorithm: ratelimit.TokenBucket, Rate: 2, Period: time.Second},
+			steps: []step{
+				{want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 500 * time.Millisecond}},
+				{want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 0, ResetAfter: time.Second}},
+				{want: ratelimit.Result{Limit: 2, RetryAfter: 500 * time.Millisecond, ResetAfter: time.Second}},
+				{advance: 500 * time.Millisecond,
+					want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 0, ResetAfter: time.Second}},
+				// 桶满了之后不会再多放令牌
+				{advance: 2 * time.Second,
+					want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 500 * time.Millisecond}},
+			},
+		},
+		{
+			name:  "token bucket burst",
+			limit: ratelimit.Limit{Algorithm: ratelimit.TokenBucket, Rate: 1, Period: time.Second, Burst: 3},
+			steps: []step{
+				{want: ratelimit.Result{Allowed: true, Limit: 3, Remaining: 2, ResetAfter: time.Second}},
+				{want: ratelimit.Result{Allowed: true, Limit: 3, Remaining: 1, ResetAfter: 2 * time.Second}},
+				{want: ratelimit.Result{Allowed: true, Limit: 3, Remaining: 0, ResetAfter: 3 * time.Second}},
+				{want: ratelimit.Result{Limit: 3, RetryAfter: time.Second, ResetAfter: 3 * time.Second}},
+			},
+		},
+		{
+			name:  "fixed window",
+			limit: ratelimit.Limit{Algorithm: ratelimit.FixedWindow, Rate: 2, Period: time.Second},
+			steps: []step{
+				{want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: time.Second}},
+				{want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 0, ResetAfter: time.Second}},
+				{advance: 400 * time.Millisecond,
+					want: ratelimit.Result{Limit: 2, RetryAfter: 600 * time.Millisecond, ResetAfter: 600 * time.Millisecond}},
+				// 下一个窗口
+				{advance: 600 * time.Millisecond,
+					want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: time.Second}},
+			},
+		},
+		{
+			name:  "sliding window",
+			limit: ratelimit.Limit{Algorithm: ratelimit.SlidingWindow, Rate: 2, Period: time.Second},
+			steps: []step{
+				{want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 2 * time.Second}},
+				{want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 0, ResetAfter: 2 * time.Second}},
+				{advance: 500 * time.Millisecond,
+					want: ratelimit.Result{Limit: 2, RetryAfter: time.Second, ResetAfter: 1500 * time.Millisecond}},
+				// 进入下一个窗口, 但是上一个窗口的权重还是 1
+				{advance: 500 * time.Millisecond,
+					want: ratelimit.Result{Limit: 2, RetryAfter: 500 * time.Millisecond, ResetAfter: time.Second}},
+				{advance: 500 * time.Millisecond,
+					want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 0, ResetAfter: 1500 * time.Millisecond}},
+				// 中间隔了一个窗口, 计数清零
+				{advance: 2 * time.Second,
+					want: ratelimit.Result{Allowed: true, Limit: 2, Remaining: 1, ResetAfter: 1500 * time.Millisecond}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			s := newTestStore(t)
+			s.now = func() time.Time {
+				return now
+			}
+			for i, st := range tc.steps {
+				now = now.Add(st.advance)
+				res, err := s.Allow(context.Background(), "key", tc.limit)
+				require.NoError(t, err)
+				assert.Equal(t, st.want, res, "step %d", i)
+			}
+		})
+	}
+}
+
+func TestStore_AllowUnknownAlgorithm(t *testing.T) {
+	s := newTestStore(t)
+	_, err := s.Allow(context.Background(), "key", ratelimit.Limit{Algorithm: 100, Rate: 1, Period: time.Second})
+	assert.EqualError(t, err, "ratelimit: 不支持的限流算法 100")
+}
+
+func TestStore_AllowSubMillisecond(t *testing.T) {
+	s := newTestStore(t)
+	_, err := s.Allow(context.Background(), "key", ratelimit.Limit{Algorithm: ratelimit.FixedWindow, Rate: 1, Period: time.Microsecond})
+	assert.EqualError(t, err, "ratelimit: Period 不能小于 1ms, 实际是 1µs")
+}
+
+func TestStore_AllowKeys(t *testing.T) {
+	s := newTestStore(t)
+	limit := ratelimit.Limit{Algorithm: ratelimit.FixedWindow, Rate: 1, Period: time.Minute}
+	res, err := s.Allow(context.Background(), "a", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	res, err = s.Allow(context.Background(), "a", limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	// 不同的 key 额度是分开的
+	res, err = s.Allow(context.Background(), "b", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestStore_Expiration(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := NewStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), StoreWithPrefix("test"))
+	// 刚好是一个窗口的开始
+	now := time.Unix(960, 0)
+	s.now = func() time.Time {
+		return now
+	}
+	limit := ratelimit.Limit{Algorithm: ratelimit.FixedWindow, Rate: 1, Period: time.Minute}
+	_, err := s.Allow(context.Background(), "key", limit)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, mr.TTL("test:key"))
+
+	// 窗口结束之后 key 就过期了
+	mr.FastForward(time.Minute)
+	assert.False(t, mr.Exists("test:key"))
+}
+
+func TestStore_AllowError(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := NewStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	mr.SetError("redis 挂了")
+	_, err := s.Allow(context.Background(), "key", ratelimit.Limit{Rate: 1, Period: time.Second})
+	assert.Error(t, err)
+}
+
+func newTestStore(t *testing.T) *Store {
+	mr := miniredis.RunT(t)
+	return NewStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Algorithm 限流算法
+type Algorithm int
+
+const (
+	// TokenBucket 令牌桶, 按照 Rate/Period 的速度放令牌, 最多攒 Burst 个, 允许一定的突发流量
+	TokenBucket Algorithm = iota
+	// FixedWindow 固定窗口, 每个 Period 最多 Rate 个请求, 窗口交界的地方可能会有两倍的流量
+	FixedWindow
+	// SlidingWindow 滑动窗口, 用上一个窗口的计数按照时间加权估算, 比固定窗口平滑
+	SlidingWindow
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case TokenBucket:
+		return "token_bucket"
+	case FixedWindow:
+		return "fixed_window"
+	case SlidingWindow:
+		return "sliding_window"
+	}
+	return "unknown"
+}
+
+// Limit 限流的规则, 例如每分钟 100 个请求
+//
+//	ratelimit.Limit{Algorithm: ratelimit.SlidingWindow, Rate: 100, Period: time.Minute}
+type Limit struct {
+	Algorithm Algorithm
+	// 每个 Period 允许的请求数
+	Rate   int
+	Period time.Duration
+	// 只有 TokenBucket 用, 桶的容量, 默认和 Rate 一样
+	Burst int
+}
+
+// Capacity 令牌桶的容量, 没有设置 Burst 的时候和 Rate 一样
+func (l Limit) Capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// Result 一次限流判断的结果, 用来设置 X-RateLimit-* 头部
+type Result struct {
+	Allowed bool
+	// 对应 X-RateLimit-Limit
+	Limit int
+	// 还能发多少个请求, 对应 X-RateLimit-Remaining
+	Remaining int
+	// 被拒绝之后多久可以重试, 对应 Retry-After, 允许的时候是 0
+	RetryAfter time.Duration
+	// 额度完全恢复还要多久, 对应 X-RateLimit-Reset
+	ResetAfter time.Duration
+}
+
+// Store 保存限流的状态, 每调用一次 Allow 消耗 key 的一个额度
+// 被拒绝的请求不消耗额度
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
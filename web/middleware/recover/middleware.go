@@ -1,24 +1,81 @@
 package recover
 
-import "my-frame/web"
+import (
+	"fmt"
+	"log"
+	"my-frame/web"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicError 把 panic 转成 error 记录到 Context 上
+// 外层的 middleware, 例如 accesslog 和 opentelemetry, 可以通过 ctx.Err() 知道发生了 panic
+//
+//	var pe *recover.PanicError
+//	if errors.As(ctx.Err(), &pe) {
+//		// pe.Value, pe.Stack
+//	}
+type PanicError struct {
+	// recover() 拿到的值
+	Value any
+	// 发生 panic 的时候的调用栈
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("recover: panic: %v", e.Value)
+}
+
+// Unwrap panic 的是 error 的时候, 可以用 errors.Is 和 errors.As 判断
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
 
 type MiddlewareBuilder struct {
+	// 设置了 StatusCode 的时候用 StatusCode 和 Data 作为响应
+	// 都没有设置的话交给 HTTPServer 的 ErrorHandler, 默认是 500
 	StatusCode int
 	Data       []byte
 
 	//log        func(err any)
-	Log func(ctx *web.Context)
-	//log        func(stack string)
+	//Log func(ctx *web.Context)
+	// 只有 ctx 拿不到 panic 的值和调用栈, 所以改成了现在这样
+	// 默认用 log 输出
+	Log func(ctx *web.Context, err any, stack []byte)
+	// Render 自定义响应, 优先级比 StatusCode 和 Data 高
+	Render func(ctx *web.Context, err *PanicError)
 }
 
 func (m MiddlewareBuilder) Build() web.Middleware {
+	if m.Log == nil {
+		m.Log = func(ctx *web.Context, err any, stack []byte) {
+			log.Printf("recover: %s %s panic: %v\n%s", ctx.Req.Method, ctx.Req.URL.Path, err, stack)
+		}
+	}
 	return func(next web.HandleFunc) web.HandleFunc {
 		return func(ctx *web.Context) {
 			defer func() {
-				if err := recover(); err != nil {
+				err := recover()
+				if err == nil {
+					return
+				}
+				// 这是 net/http 约定的中断响应的方式, 不打日志, 继续往上抛给 net/http 处理
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				pe := &PanicError{Value: err, Stack: debug.Stack()}
+				m.Log(ctx, err, pe.Stack)
+				// 先交给 ErrorHandler, 顺便把 error 记录到 Context 上
+				ctx.Error(pe)
+				switch {
+				case m.Render != nil:
+					m.Render(ctx, pe)
+				case m.StatusCode != 0:
+					// ErrorHandler 可能设置了 Content-Type, 交给 HTTPServer 重新检测
+					ctx.RespHeader.Del("Content-Type")
 					ctx.RespData = m.Data
 					ctx.RespStatusCode = m.StatusCode
-					m.Log(ctx)
 				}
 			}()
 			next(ctx)
@@ -1,23 +1,122 @@
 package recover
 
 import (
-	"fmt"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"my-frame/web"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestMiddlewareBuilder_Build(t *testing.T) {
+	errDB := errors.New("db error")
+	testCases := []struct {
+		name    string
+		builder MiddlewareBuilder
+		value   any
+
+		wantCode int
+		wantBody string
+	}{
+		{
+			name: "static",
+			builder: MiddlewareBuilder{
+				StatusCode: 500,
+				Data:       []byte("你 Panic 了"),
+			},
+			value:    "发生 panic",
+			wantCode: http.StatusInternalServerError,
+			wantBody: "你 Panic 了",
+		},
+		{
+			// 交给 HTTPServer 的 ErrorHandler
+			name:     "error handler",
+			value:    "发生 panic",
+			wantCode: http.StatusInternalServerError,
+			wantBody: `{"code":500,"message":"Internal Server Error"}`,
+		},
+		{
+			name: "render",
+			builder: MiddlewareBuilder{
+				StatusCode: 500,
+				Render: func(ctx *web.Context, err *PanicError) {
+					ctx.RespStatusCode = http.StatusServiceUnavailable
+					ctx.RespData = []byte(err.Error())
+				},
+			},
+			value:    errDB,
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: "recover: panic: db error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				logVal   any
+				logStack []byte
+				ctxErr   error
+			)
+			tc.builder.Log = func(ctx *web.Context, err any, stack []byte) {
+				logVal, logStack = err, stack
+			}
+			// 外层的 middleware 可以拿到 panic
+			outer := func(next web.HandleFunc) web.HandleFunc {
+				return func(ctx *web.Context) {
+					next(ctx)
+					ctxErr = ctx.Err()
+				}
+			}
+			server := web.NewHTTPServer(web.ServerWithMiddleware(outer, tc.builder.Build()))
+			server.Get("/user", func(ctx *web.Context) {
+				panic(tc.value)
+			})
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user", nil))
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+			assert.Equal(t, tc.value, logVal)
+			assert.Contains(t, string(logStack), "runtime/debug.Stack")
+
+			var pe *PanicError
+			require.True(t, errors.As(ctxErr, &pe))
+			assert.Equal(t, tc.value, pe.Value)
+			assert.Equal(t, logStack, pe.Stack)
+			if err, ok := tc.value.(error); ok {
+				assert.True(t, errors.Is(ctxErr, err))
+			}
+		})
+	}
+}
+
+func TestMiddlewareBuilder_AbortHandler(t *testing.T) {
+	logged := false
 	builder := MiddlewareBuilder{
-		StatusCode: 500,
-		Data:       []byte("你 Panic 了"),
-		Log: func(ctx *web.Context) {
-			fmt.Printf("panic 路径: %s", ctx.Req.URL.String())
+		Log: func(ctx *web.Context, err any, stack []byte) {
+			logged = true
 		},
 	}
-	server := web.NewHTTPServer(web.ServerWithMiddleware(builder.Build()))
-	server.Get("/user", func(ctx *web.Context) {
-		panic("发生 panic")
+	handler := builder.Build()(func(ctx *web.Context) {
+		panic(http.ErrAbortHandler)
+	})
+	ctx := &web.Context{Req: httptest.NewRequest(http.MethodGet, "/user", nil)}
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler(ctx)
 	})
-	server.Start(":8081")
+	assert.False(t, logged)
+}
 
+func TestMiddlewareBuilder_DefaultLog(t *testing.T) {
+	// 没有设置 Log 也不会 panic
+	handler := MiddlewareBuilder{}.Build()(func(ctx *web.Context) {
+		panic("发生 panic")
+	})
+	ctx := &web.Context{Req: httptest.NewRequest(http.MethodGet, "/user", nil)}
+	assert.NotPanics(t, func() {
+		handler(ctx)
+	})
+	assert.Equal(t, http.StatusInternalServerError, ctx.RespStatusCode)
 }
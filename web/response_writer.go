@@ -0,0 +1,71 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// responseWriter 记录用户有没有直接写过响应, 以及写了多少数据
+// 直接写过的话, flashResp 就不能再写 RespData 和 RespStatusCode 了
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+	// 直接写的时候用的响应码
+	status int
+	// 写出去的 body 的字节数
+	size int
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	if !w.written {
+		w.status = statusCode
+	}
+	w.written = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(data []byte) (int, error) {
+	if !w.written {
+		w.status = http.StatusOK
+	}
+	w.written = true
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+// Flush 流式响应用, 底层不支持的话什么也不做
+func (w *responseWriter) Flush() {
+	w.written = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack WebSocket 升级的时候用, 接管连接之后 flashResp 就不能再写了
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("web: http.ResponseWriter 不支持 Hijack")
+	}
+	w.written = true
+	return h.Hijack()
+}
+
+// Unwrap 让 http.ResponseController 能够拿到原本的 http.ResponseWriter
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyAllowedForStatus 1xx, 204 和 304 不允许有 body
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -2,9 +2,28 @@ package web
 
 import (
 	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 )
 
+// routeTable 路由树的抽象
+// 默认的 router 不是线程安全的, 需要运行期间动态注册路由的
+// 可以用 ServerWithSafeRouter 换成 safeRouter
+type routeTable interface {
+	addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware)
+	// addNamedRoute 和 addRoute 一样, 只是 Routes 里面显示的 handler 名字由调用者指定
+	// 例如 WebSocket 注册的是包装出来的闭包, 名字要用用户传入的 handler 的
+	addNamedRoute(method string, path string, handlerName string, handleFunc HandleFunc, mdls ...Middleware)
+	findRoute(method string, path string) (*matchInfo, bool)
+	removeRoute(method string, path string) bool
+	allowedMethods(path string) []string
+	routes() []RouteInfo
+}
+
+var _ routeTable = &router{}
+
 // router 用来支持对路由树的操作
 // 代表路由树(森林)
 type router struct {
@@ -36,10 +55,16 @@ func newRouter() router {
 // 已经注册了的路由，无法被覆盖，例如 /user/home注册两次，会冲突
 // path 必须以 / 开始并且结尾不能有 /，中间也不允许有连续的 不能在同一个位置注册不同的参数路由，例如 /user/:id 和 /user/:name 冲突
 // 不能在同一个位置同时注册通配符路由和参数路由，例如 /user/:id和 /user/* 冲突
+// 正则路由 /user/:id(^[0-9]+$) 也是一种参数路由, 同样不能和参数路由、通配符路由注册在同一个位置
 // 同名路径参数，在路由匹配的时候，值会被覆盖，例如/user/:id/abc/:id，那么 /user/123/abc/456 最终 id = 456
 
 // path  必须以 / 开头, 不能以 / 结尾, 中间也不能有连续的 //
-func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
+// mdls 是只作用于该路由的 middleware, 会被挂在对应的节点上
+func (r *router) addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware) {
+	r.addNamedRoute(method, path, funcName(handleFunc), handleFunc, mdls...)
+}
+
+func (r *router) addNamedRoute(method string, path string, handlerName string, handleFunc HandleFunc, mdls ...Middleware) {
 	// path为空的校验(限制)
 	if path == "" {
 		panic("web: 路径不能为空字符串")
@@ -72,8 +97,7 @@ func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
 		if root.handler != nil {
 			panic("web: 路由冲突, 重复注册[/]")
 		}
-		root.handler = handleFunc
-		root.route = "/"
+		root.setHandler("/", handlerName, handleFunc, mdls)
 		return
 	}
 
@@ -93,29 +117,43 @@ func (r *router) addRoute(method string, path string, handleFunc HandleFunc) {
 	if root.handler != nil {
 		panic(fmt.Sprintf("web: 路由冲突, 重复注册[%s]", path))
 	}
-	root.handler = handleFunc
-	root.route = path
-
+	root.setHandler(path, handlerName, handleFunc, mdls)
 }
 
 func (n *node) childOfCreate(seg string) *node {
 
 	if seg[0] == ':' {
-		if n.starChild != nil {
-			panic("web: 不允许同时注册路径参数和通配符匹配, 已有通配符匹配")
+		paramName, expr, isReg := parseParam(seg)
+		if isReg {
+			return n.childOfNonStatic(seg, paramName, expr)
 		}
-		n.paramChild = &node{
-			path: seg,
-		}
-		return n.paramChild
+		return n.childOfParam(seg, paramName)
 	}
 
-	if seg == "*" {
+	if seg[0] == '*' {
 		if n.paramChild != nil {
 			panic("web: 不允许同时注册路径参数和通配符匹配, 已有路径参数")
 		}
+		if n.regChild != nil {
+			panic("web: 不允许同时注册正则匹配和通配符匹配, 已有正则匹配")
+		}
+		if n.starChild != nil {
+			// 同一个位置上不能有不同名字的通配符, 例如 /static/* 和 /static/*file
+			if n.starChild.path != seg {
+				panic(fmt.Sprintf("web: 路由冲突, 通配符路由冲突, 已有 [%s], 新注册 [%s]", n.starChild.path, seg))
+			}
+			return n.starChild
+		}
+		// * 匹配到的值放在 PathParams["*"] 里面
+		// *file 匹配到的值放在 PathParams["file"] 里面
+		paramName := seg[1:]
+		if paramName == "" {
+			paramName = seg
+		}
 		n.starChild = &node{
-			path: seg,
+			path:      seg,
+			paramName: paramName,
+			typ:       nodeTypeAny,
 		}
 		return n.starChild
 	}
@@ -134,6 +172,78 @@ func (n *node) childOfCreate(seg string) *node {
 	return res
 }
 
+// parseParam 解析参数路由
+// :id 返回 id, "", false
+// :id(^[0-9]+$) 返回 id, ^[0-9]+$, true
+// 参数名不能为空, 例如 : 和 :(^[0-9]+$)
+func parseParam(seg string) (string, string, bool) {
+	seg = seg[1:]
+	idx := strings.Index(seg, "(")
+	if idx < 0 {
+		if seg == "" {
+			panic("web: 路径参数名不能为空 [:]")
+		}
+		return seg, "", false
+	}
+	if seg[len(seg)-1] != ')' || idx == len(seg)-2 {
+		panic(fmt.Sprintf("web: 非法的正则路由 [%s]", seg))
+	}
+	if idx == 0 {
+		panic(fmt.Sprintf("web: 路径参数名不能为空 [:%s]", seg))
+	}
+	return seg[:idx], seg[idx+1 : len(seg)-1], true
+}
+
+func (n *node) childOfParam(seg string, paramName string) *node {
+	if n.starChild != nil {
+		panic("web: 不允许同时注册路径参数和通配符匹配, 已有通配符匹配")
+	}
+	if n.regChild != nil {
+		panic(fmt.Sprintf("web: 不允许同时注册路径参数和正则匹配, 已有正则匹配 [%s]", n.regChild.path))
+	}
+	if n.paramChild != nil {
+		// 同一个位置上不能有不同名字的参数, 例如 /user/:id 和 /user/:name
+		if n.paramChild.path != seg {
+			panic(fmt.Sprintf("web: 路由冲突, 参数路由冲突, 已有 [%s], 新注册 [%s]", n.paramChild.path, seg))
+		}
+		return n.paramChild
+	}
+	n.paramChild = &node{
+		path:      seg,
+		paramName: paramName,
+		typ:       nodeTypeParam,
+	}
+	return n.paramChild
+}
+
+// childOfNonStatic 正则路由, 在注册的时候就编译好正则表达式
+func (n *node) childOfNonStatic(seg string, paramName string, expr string) *node {
+	if n.starChild != nil {
+		panic("web: 不允许同时注册正则匹配和通配符匹配, 已有通配符匹配")
+	}
+	if n.paramChild != nil {
+		panic(fmt.Sprintf("web: 不允许同时注册正则匹配和路径参数, 已有路径参数 [%s]", n.paramChild.path))
+	}
+	if n.regChild != nil {
+		// 同一个位置上只能有一个正则路由
+		if n.regChild.path != seg {
+			panic(fmt.Sprintf("web: 路由冲突, 正则路由冲突, 已有 [%s], 新注册 [%s]", n.regChild.path, seg))
+		}
+		return n.regChild
+	}
+	regExpr, err := regexp.Compile(expr)
+	if err != nil {
+		panic(fmt.Sprintf("web: 正则表达式错误 [%s]: %v", expr, err))
+	}
+	n.regChild = &node{
+		path:      seg,
+		paramName: paramName,
+		regExpr:   regExpr,
+		typ:       nodeTypeReg,
+	}
+	return n.regChild
+}
+
 func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 	// 基本上是不是也是沿着树深度查找下去?
 	root, ok := r.trees[method]
@@ -153,20 +263,28 @@ func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 
 	// 按照斜杠切割
 	segs := strings.Split(path, "/")
+
+	// 先找带 handler 的节点, 找不到会回溯
+	info := &matchInfo{}
+	if root.match(segs, info) {
+		return info, true
+	}
+
+	// 回溯之后也找不到有 handler 的节点
+	// 那么就按照优先级一路找下去, 看看是不是有这个节点
 	var pathParams map[string]string
 	for _, seg := range segs {
-		child, paramChild, found := root.chlidOf(seg)
+		child, found := root.chlidOf(seg)
 		if !found {
 			return nil, false
 		}
 
-		// 命中了路径参数
-		if paramChild {
+		// 命中了路径参数或者正则匹配
+		if child.paramName != "" {
 			if pathParams == nil {
 				pathParams = make(map[string]string)
 			}
-			// path 是 :id 这种形式, 把: 去掉
-			pathParams[child.path[1:]] = seg
+			pathParams[child.paramName] = seg
 		}
 
 		root = child
@@ -183,30 +301,229 @@ func (r *router) findRoute(method string, path string) (*matchInfo, bool) {
 
 }
 
-// 优先考虑静态匹配, 匹配不上, 再考虑通配符匹配
-// 第一个返回值是子节点
-// 第二个是标记是否是路径参数
-// 第三个标记命中了没有
-func (n *node) chlidOf(path string) (*node, bool, bool) {
-	if n.chlidren == nil {
-		if n.paramChild != nil {
-			return n.paramChild, true, true
+// match 在 n 的子树里面查找 segs 对应的, 并且有 handler 的节点
+// 按照 静态匹配 > 正则匹配 > 路径参数 > 通配符匹配 的优先级一个个尝试
+// 某个分支走到底都没有 handler 的话, 就回溯回来尝试下一种匹配方式
+// 例如注册了 /a/b/d 和 /a/*, 那么 /a/b/c 会先走 /a/b 这个分支, 走不通再回到 /a/*
+func (n *node) match(segs []string, info *matchInfo) bool {
+	if len(segs) == 0 {
+		if n.handler == nil {
+			return false
 		}
-		return n.starChild, false, n.starChild != nil
+		info.n = n
+		return true
 	}
 
-	child, ok := n.chlidren[path]
-	if !ok {
-		if n.paramChild != nil {
-			return n.paramChild, true, true
+	seg := segs[0]
+	if child, ok := n.chlidren[seg]; ok && child.match(segs[1:], info) {
+		return true
+	}
+
+	if n.regChild != nil && n.regChild.regExpr.MatchString(seg) && n.regChild.match(segs[1:], info) {
+		info.addValue(n.regChild.paramName, seg)
+		return true
+	}
+
+	if n.paramChild != nil && n.paramChild.match(segs[1:], info) {
+		info.addValue(n.paramChild.paramName, seg)
+		return true
+	}
+
+	if n.starChild != nil {
+		// 中间的通配符只匹配一段
+		if len(segs) > 1 && n.starChild.match(segs[1:], info) {
+			return true
+		}
+		// 末尾的通配符, 把剩下的所有段都吞掉
+		if n.starChild.handler != nil {
+			info.n = n.starChild
+			info.addValue(n.starChild.paramName, strings.Join(segs, "/"))
+			return true
 		}
-		return n.starChild, false, n.starChild != nil
 	}
-	return child, false, ok
+	return false
+}
+
+// removeRoute 删除注册的路由, path 要和注册的时候一模一样, 例如 /user/:id
+// 返回 false 代表这个路由没有注册过
+// 删除之后没有 handler 也没有子节点的节点会被一并清理掉
+// 这样同一个位置可以重新注册别的参数路由, 例如删掉 /user/:id 之后注册 /user/:name
+func (r *router) removeRoute(method string, path string) bool {
+	root, ok := r.trees[method]
+	if !ok || path == "" || path[0] != '/' {
+		return false
+	}
+	if path == "/" {
+		if root.handler == nil {
+			return false
+		}
+		root.clearHandler()
+	} else {
+		segs := strings.Split(strings.Trim(path, "/"), "/")
+		if !root.remove(segs) {
+			return false
+		}
+	}
+	if root.isEmpty() {
+		delete(r.trees, method)
+	}
+	return true
+}
+
+// remove 按照注册时候的 path 精确查找, 而不是按照匹配规则查找
+func (n *node) remove(segs []string) bool {
+	if len(segs) == 0 {
+		if n.handler == nil {
+			return false
+		}
+		n.clearHandler()
+		return true
+	}
+
+	seg := segs[0]
+	child := n.childOfPattern(seg)
+	if child == nil || !child.remove(segs[1:]) {
+		return false
+	}
+	if child.isEmpty() {
+		switch child.typ {
+		case nodeTypeReg:
+			n.regChild = nil
+		case nodeTypeParam:
+			n.paramChild = nil
+		case nodeTypeAny:
+			n.starChild = nil
+		default:
+			delete(n.chlidren, seg)
+		}
+	}
+	return true
+}
+
+// childOfPattern 找到注册时候 seg 对应的子节点
+func (n *node) childOfPattern(seg string) *node {
+	var child *node
+	switch {
+	case seg == "":
+		return nil
+	case seg[0] == ':':
+		if _, _, isReg := parseParam(seg); isReg {
+			child = n.regChild
+		} else {
+			child = n.paramChild
+		}
+	case seg[0] == '*':
+		child = n.starChild
+	default:
+		return n.chlidren[seg]
+	}
+	if child == nil || child.path != seg {
+		return nil
+	}
+	return child
+}
+
+// setHandler 注册的时候就把路由上的 middleware 和 handler 组装好
+// 这样每个请求不需要再组装一遍
+func (n *node) setHandler(route string, handlerName string, handleFunc HandleFunc, mdls []Middleware) {
+	n.handler = handleFunc
+	n.handlerName = handlerName
+	n.route = route
+	n.mdls = mdls
+	chain := checkBodySize(handleFunc)
+	for i := len(mdls) - 1; i >= 0; i-- {
+		chain = mdls[i](chain)
+	}
+	n.chain = chain
+}
+
+func (n *node) clearHandler() {
+	n.handler = nil
+	n.handlerName = ""
+	n.route = ""
+	n.mdls = nil
+	n.chain = nil
+}
+
+func (n *node) isEmpty() bool {
+	return n.handler == nil && len(n.chlidren) == 0 &&
+		n.regChild == nil && n.paramChild == nil && n.starChild == nil
+}
+
+// allowedMethods 找出 path 在哪些 HTTP 方法下面有 handler
+// 用于 405 和 OPTIONS 响应里面的 Allow 头部
+func (r *router) allowedMethods(path string) []string {
+	var res []string
+	hasGet, hasHead, hasOptions := false, false, false
+	for method := range r.trees {
+		info, ok := r.findRoute(method, path)
+		if !ok || info.n.handler == nil {
+			continue
+		}
+		res = append(res, method)
+		switch method {
+		case http.MethodGet:
+			hasGet = true
+		case http.MethodHead:
+			hasHead = true
+		case http.MethodOptions:
+			hasOptions = true
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+
+	// HEAD 会用 GET 的路由来处理, OPTIONS 会自动应答
+	// 所以只要注册了 GET, 就支持 HEAD; 只要有一个方法, 就支持 OPTIONS
+	if hasGet && !hasHead {
+		res = append(res, http.MethodHead)
+	}
+	if !hasOptions {
+		res = append(res, http.MethodOptions)
+	}
+	// map 遍历是随机的, 排个序保证输出稳定
+	sort.Strings(res)
+	return res
+}
+
+// 优先考虑静态匹配, 匹配不上, 再考虑正则匹配, 然后是路径参数, 最后是通配符匹配
+// 第一个返回值是子节点
+// 第二个标记命中了没有
+func (n *node) chlidOf(path string) (*node, bool) {
+	if n.chlidren != nil {
+		if child, ok := n.chlidren[path]; ok {
+			return child, true
+		}
+	}
+
+	if n.regChild != nil && n.regChild.regExpr.MatchString(path) {
+		return n.regChild, true
+	}
+
+	if n.paramChild != nil {
+		return n.paramChild, true
+	}
+	return n.starChild, n.starChild != nil
 
 }
 
+type nodeType int
+
+const (
+	// 静态路由
+	nodeTypeStatic nodeType = iota
+	// 正则路由
+	nodeTypeReg
+	// 路径参数路由
+	nodeTypeParam
+	// 通配符路由
+	nodeTypeAny
+)
+
 type node struct {
+	typ nodeType
+
 	route string
 
 	path string
@@ -221,8 +538,24 @@ type node struct {
 	// 路径参数
 	paramChild *node
 
+	// 正则匹配, 例如 :id(^[0-9]+$)
+	regChild *node
+	// 正则路由才有, 注册的时候就编译好
+	regExpr *regexp.Regexp
+
+	// 路径参数和正则路由的参数名, 例如 :id(^[0-9]+$) 就是 id
+	paramName string
+
 	// 缺一个代表用户注册的业务逻辑
 	handler HandleFunc
+	// Routes 里面显示的函数名, 被 WrapErr 之类的包装过的话是原始 handler 的名字
+	handlerName string
+
+	// 只作用在这个路由上的 middleware
+	// 例如路由分组上注册的 middleware
+	mdls []Middleware
+	// mdls 和 handler 组装好的链条
+	chain HandleFunc
 }
 
 type matchInfo struct {
@@ -230,6 +563,20 @@ type matchInfo struct {
 	pathParams map[string]string
 }
 
+// addValue match 是回溯的, 后面的段先加进来, 所以已经有的 key 不能覆盖
+// 这样同名参数取的是最后一段的值, 例如 /user/:id/abc/:id 匹配 /user/123/abc/456 得到 id = 456
+func (m *matchInfo) addValue(key string, value string) {
+	if m.pathParams == nil {
+		// 大多数情况, 参数路径只会有一段
+		m.pathParams = map[string]string{key: value}
+		return
+	}
+	if _, ok := m.pathParams[key]; ok {
+		return
+	}
+	m.pathParams[key] = value
+}
+
 /*
 面试要点(1):
 路由树算法?
@@ -241,6 +588,7 @@ type matchInfo struct {
 
 路由查找会回溯吗?
 这也是和 Web 框架相关的，我们在课程上是不支持的。在这里可以简单描述可回溯和不可回溯之间的区别，可以是田课程体user/123/home 和 /user/*\/*。我这里不支持是因为这个特性非常鸡肋。
+后面为了支持 /static/* 这种末尾通配符匹配多段路径, 还是加上了回溯: 某个分支走到底都没有 handler, 就退回来尝试优先级更低的匹配方式。
 
 Web 框架是怎么组织路由树的?
 一个 HTTP 方法一颗路由树，也可以考虑一颗路由树，每个节点标记自己支持的 HTTP 方法。在课程中可以看到，前者是比较主流的
@@ -253,6 +601,7 @@ Web 框架是怎么组织路由树的?
 
 路由树是线程安全的吗?
 严格来说也是跟 Web 框架相关的。大多数都不是线程安全的，这是为了性能。所以才要求大家一定要先注册路由，后启动 Web 服务器。如果你有运行期间动态添加路由的需求，只需要利用装饰器模式，就可以将一个线程不安全的封装为线程安全的路由树。
+我们的 safeRouter 就是这么做的, 用读写锁把 router 装饰了一下, 通过 ServerWithSafeRouter 启用。
 
 具体匹配方式的实现原理。
 课程上我们讨论了静态匹配、通配符匹配和路径匹配, 作业里面要求大家照着实现一个正则匹配。其实核心就是划定优先级, 然后一种种匹配方式挨个匹配过去。
@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"reflect"
+	"regexp"
 	"testing"
 )
 
@@ -102,8 +103,10 @@ func TestRouter_addRoute(t *testing.T) {
 							},
 						},
 						starChild: &node{
-							path:    "*",
-							handler: mockHandler,
+							path:      "*",
+							typ:       nodeTypeAny,
+							paramName: "*",
+							handler:   mockHandler,
 						},
 					},
 				},
@@ -183,10 +186,26 @@ func (r *router) equal(y *router) (string, bool) {
 }
 
 func (n *node) equal(y *node) (string, bool) {
+	if y == nil {
+		return fmt.Sprintf("目标节点 %s 为 nil", n.path), false
+	}
 	if n.path != y.path {
 		return fmt.Sprintf("节点路径不匹配"), false
 	}
 
+	if n.typ != y.typ {
+		return fmt.Sprintf("%s 节点类型不相等", n.path), false
+	}
+
+	if n.paramName != y.paramName {
+		return fmt.Sprintf("%s 节点参数名不相等", n.path), false
+	}
+
+	if (n.regExpr == nil) != (y.regExpr == nil) ||
+		(n.regExpr != nil && n.regExpr.String() != y.regExpr.String()) {
+		return fmt.Sprintf("%s 节点正则表达式不相等", n.path), false
+	}
+
 	if n.starChild != nil {
 		msg, ok := n.starChild.equal(y.starChild)
 		if !ok {
@@ -194,6 +213,20 @@ func (n *node) equal(y *node) (string, bool) {
 		}
 	}
 
+	if n.paramChild != nil {
+		msg, ok := n.paramChild.equal(y.paramChild)
+		if !ok {
+			return msg, ok
+		}
+	}
+
+	if n.regChild != nil {
+		msg, ok := n.regChild.equal(y.regChild)
+		if !ok {
+			return msg, ok
+		}
+	}
+
 	if len(n.chlidren) != len(y.chlidren) {
 		return fmt.Sprintf("子节点数量不相等"), false
 	}
@@ -355,3 +388,321 @@ func TestRoute_findRoute(t *testing.T) {
 	}
 
 }
+
+func TestRouter_regRoute(t *testing.T) {
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/user/:id(^[0-9]+$)", mockHandler)
+	r.addRoute(http.MethodGet, "/user/:id(^[0-9]+$)/detail", mockHandler)
+	r.addRoute(http.MethodGet, "/user/home", mockHandler)
+	r.addRoute(http.MethodGet, "/order/:name", mockHandler)
+	r.addRoute(http.MethodGet, "/order/:name/detail", mockHandler)
+
+	wantRouter := &router{
+		trees: map[string]*node{
+			http.MethodGet: {
+				path: "/",
+				chlidren: map[string]*node{
+					"user": {
+						path: "user",
+						chlidren: map[string]*node{
+							"home": {path: "home", handler: mockHandler},
+						},
+						regChild: &node{
+							path:      ":id(^[0-9]+$)",
+							typ:       nodeTypeReg,
+							paramName: "id",
+							regExpr:   regexp.MustCompile("^[0-9]+$"),
+							handler:   mockHandler,
+							chlidren: map[string]*node{
+								"detail": {path: "detail", handler: mockHandler},
+							},
+						},
+					},
+					"order": {
+						path: "order",
+						paramChild: &node{
+							path:      ":name",
+							typ:       nodeTypeParam,
+							paramName: "name",
+							handler:   mockHandler,
+							chlidren: map[string]*node{
+								"detail": {path: "detail", handler: mockHandler},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	msg, ok := wantRouter.equal(&r)
+	assert.True(t, ok, msg)
+
+	testCases := []struct {
+		name       string
+		path       string
+		wantFound  bool
+		wantRoute  string
+		wantParams map[string]string
+	}{
+		{
+			name:       "reg",
+			path:       "/user/123",
+			wantFound:  true,
+			wantRoute:  "/user/:id(^[0-9]+$)",
+			wantParams: map[string]string{"id": "123"},
+		},
+		{
+			name:       "reg child",
+			path:       "/user/123/detail",
+			wantFound:  true,
+			wantRoute:  "/user/:id(^[0-9]+$)/detail",
+			wantParams: map[string]string{"id": "123"},
+		},
+		{
+			// 静态匹配优先
+			name:      "static first",
+			path:      "/user/home",
+			wantFound: true,
+			wantRoute: "/user/home",
+		},
+		{
+			name: "reg not match",
+			path: "/user/abc",
+		},
+		{
+			name:       "param",
+			path:       "/order/abc",
+			wantFound:  true,
+			wantRoute:  "/order/:name",
+			wantParams: map[string]string{"name": "abc"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, found := r.findRoute(http.MethodGet, tc.path)
+			assert.Equal(t, tc.wantFound, found)
+			if !found {
+				return
+			}
+			assert.Equal(t, tc.wantRoute, info.n.route)
+			assert.Equal(t, tc.wantParams, info.pathParams)
+		})
+	}
+
+	// 同一个位置, 正则和参数路由冲突
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/user/:name", mockHandler)
+	}, "web: 不允许同时注册路径参数和正则匹配, 已有正则匹配 [:id(^[0-9]+$)]")
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/order/:id(^[0-9]+$)", mockHandler)
+	}, "web: 不允许同时注册正则匹配和路径参数, 已有路径参数 [:name]")
+	// 同一个位置, 不同的正则
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/user/:id(^[a-z]+$)", mockHandler)
+	}, "web: 路由冲突, 正则路由冲突, 已有 [:id(^[0-9]+$)], 新注册 [:id(^[a-z]+$)]")
+	// 同一个位置, 不同名字的参数
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/order/:id", mockHandler)
+	}, "web: 路由冲突, 参数路由冲突, 已有 [:name], 新注册 [:id]")
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/user/*", mockHandler)
+	}, "web: 不允许同时注册正则匹配和通配符匹配, 已有正则匹配")
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/pay/:id([0-9)", mockHandler)
+	}, "web: 正则表达式错误")
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/pay/:id(", mockHandler)
+	}, "web: 非法的正则路由 [id(]")
+	// 参数名为空
+	assert.PanicsWithValue(t, "web: 路径参数名不能为空 [:(^[0-9]+$)]", func() {
+		r.addRoute(http.MethodGet, "/pay/:(^[0-9]+$)", mockHandler)
+	})
+	assert.PanicsWithValue(t, "web: 路径参数名不能为空 [:]", func() {
+		r.addRoute(http.MethodGet, "/pay/:", mockHandler)
+	})
+}
+
+func TestRouter_findRoutePriority(t *testing.T) {
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r := newRouter()
+	routes := []string{
+		"/user/home",
+		"/user/:id",
+		"/user/:id/home",
+		"/user/:id/abc/:id",
+		"/order/*",
+		"/order/detail",
+		"/a/b/d",
+		"/a/*",
+		"/static/*file",
+		"/*/aaa",
+		"/*/aaa/*",
+	}
+	for _, route := range routes {
+		r.addRoute(http.MethodGet, route, mockHandler)
+	}
+
+	testCases := []struct {
+		name       string
+		path       string
+		wantFound  bool
+		wantRoute  string
+		wantParams map[string]string
+	}{
+		{
+			// 静态匹配 > 路径参数
+			name:      "static over param",
+			path:      "/user/home",
+			wantFound: true,
+			wantRoute: "/user/home",
+		},
+		{
+			name:       "param",
+			path:       "/user/123",
+			wantFound:  true,
+			wantRoute:  "/user/:id",
+			wantParams: map[string]string{"id": "123"},
+		},
+		{
+			// /user/home 走不下去, 回溯到 /user/:id/home
+			name:       "param backtrack",
+			path:       "/user/home/home",
+			wantFound:  true,
+			wantRoute:  "/user/:id/home",
+			wantParams: map[string]string{"id": "home"},
+		},
+		{
+			// 同名参数取最后一段的值
+			name:       "repeated param",
+			path:       "/user/123/abc/456",
+			wantFound:  true,
+			wantRoute:  "/user/:id/abc/:id",
+			wantParams: map[string]string{"id": "456"},
+		},
+		{
+			// 静态匹配 > 通配符匹配
+			name:      "static over any",
+			path:      "/order/detail",
+			wantFound: true,
+			wantRoute: "/order/detail",
+		},
+		{
+			name:       "any",
+			path:       "/order/abc",
+			wantFound:  true,
+			wantRoute:  "/order/*",
+			wantParams: map[string]string{"*": "abc"},
+		},
+		{
+			// 末尾的通配符匹配多段
+			name:       "trailing any",
+			path:       "/order/detail/abc",
+			wantFound:  true,
+			wantRoute:  "/order/*",
+			wantParams: map[string]string{"*": "detail/abc"},
+		},
+		{
+			// /a/b 分支走不通, 回溯到 /a/*
+			name:       "any backtrack",
+			path:       "/a/b/c",
+			wantFound:  true,
+			wantRoute:  "/a/*",
+			wantParams: map[string]string{"*": "b/c"},
+		},
+		{
+			name:      "static deeper",
+			path:      "/a/b/d",
+			wantFound: true,
+			wantRoute: "/a/b/d",
+		},
+		{
+			name:       "named any",
+			path:       "/static/css/app.css",
+			wantFound:  true,
+			wantRoute:  "/static/*file",
+			wantParams: map[string]string{"file": "css/app.css"},
+		},
+		{
+			// 中间的通配符只匹配一段
+			name:      "middle any",
+			path:      "/xxx/aaa",
+			wantFound: true,
+			wantRoute: "/*/aaa",
+		},
+		{
+			name:       "middle and trailing any",
+			path:       "/xxx/aaa/b/c",
+			wantFound:  true,
+			wantRoute:  "/*/aaa/*",
+			wantParams: map[string]string{"*": "b/c"},
+		},
+		{
+			name: "not found",
+			path: "/xxx/bbb",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, found := r.findRoute(http.MethodGet, tc.path)
+			assert.Equal(t, tc.wantFound, found)
+			if !found {
+				return
+			}
+			assert.Equal(t, tc.wantRoute, info.n.route)
+			assert.Equal(t, tc.wantParams, info.pathParams)
+		})
+	}
+
+	assert.Panicsf(t, func() {
+		r.addRoute(http.MethodGet, "/static/*path", mockHandler)
+	}, "web: 路由冲突, 通配符路由冲突, 已有 [*file], 新注册 [*path]")
+}
+
+func TestRouter_removeRoute(t *testing.T) {
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/", mockHandler)
+	r.addRoute(http.MethodGet, "/user/:id", mockHandler)
+	r.addRoute(http.MethodGet, "/user/:id/home", mockHandler)
+	r.addRoute(http.MethodGet, "/order/:id(^[0-9]+$)", mockHandler)
+	r.addRoute(http.MethodGet, "/static/*file", mockHandler)
+	r.addRoute(http.MethodPost, "/login", mockHandler)
+
+	// 没有注册过的
+	assert.False(t, r.removeRoute(http.MethodGet, "/user"))
+	assert.False(t, r.removeRoute(http.MethodGet, "/user/:name"))
+	assert.False(t, r.removeRoute(http.MethodGet, "/order/:id"))
+	assert.False(t, r.removeRoute(http.MethodPut, "/login"))
+	assert.False(t, r.removeRoute(http.MethodGet, ""))
+	assert.False(t, r.removeRoute(http.MethodGet, "/a//b"))
+
+	// 中间节点还有子节点, 只清理 handler
+	assert.True(t, r.removeRoute(http.MethodGet, "/user/:id"))
+	_, found := r.findRoute(http.MethodGet, "/user/123/home")
+	assert.True(t, found)
+	info, found := r.findRoute(http.MethodGet, "/user/123")
+	assert.True(t, found)
+	assert.Nil(t, info.n.handler)
+
+	// 空节点被清理之后, 可以注册不同名字的参数路由
+	assert.True(t, r.removeRoute(http.MethodGet, "/user/:id/home"))
+	assert.Nil(t, r.trees[http.MethodGet].chlidren["user"])
+	r.addRoute(http.MethodGet, "/user/:name", mockHandler)
+
+	assert.True(t, r.removeRoute(http.MethodGet, "/order/:id(^[0-9]+$)"))
+	_, found = r.findRoute(http.MethodGet, "/order/123")
+	assert.False(t, found)
+
+	assert.True(t, r.removeRoute(http.MethodGet, "/static/*file"))
+	_, found = r.findRoute(http.MethodGet, "/static/a/b.css")
+	assert.False(t, found)
+
+	assert.True(t, r.removeRoute(http.MethodGet, "/"))
+	assert.False(t, r.removeRoute(http.MethodGet, "/"))
+
+	// 整棵树都空了, 就把树删掉
+	assert.True(t, r.removeRoute(http.MethodPost, "/login"))
+	_, ok := r.trees[http.MethodPost]
+	assert.False(t, ok)
+}
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// RouteInfo 注册的路由信息
+type RouteInfo struct {
+	Method  string `json:"method"`
+	Pattern string `json:"pattern"`
+	// 业务逻辑的函数名, 例如 main.main.func1
+	Handler string `json:"handler"`
+	// 路由上的 middleware 的数量, 不包括 ServerWithMiddleware 注册的
+	Middlewares int `json:"middlewares"`
+}
+
+// Routes 返回所有注册了 handler 的路由, 按照 Pattern 和 Method 排序
+// 可以用来对比不同版本之间的路由表
+func (h *HTTPServer) Routes() []RouteInfo {
+	return h.routes()
+}
+
+// RoutesHandler 把路由表输出出来, 方便排查问题
+// 默认输出 JSON, 带上 ?format=text 或者 Accept: text/plain 的时候输出文本
+// 最好只注册在内部的调试端口或者加上鉴权, 例如
+//
+//	h.Get("/debug/routes", h.RoutesHandler(), authMdl)
+func (h *HTTPServer) RoutesHandler() HandleFunc {
+	return func(ctx *Context) {
+		routes := h.Routes()
+		format, _ := ctx.QueryValue("format")
+		if format == "text" || strings.HasPrefix(ctx.Req.Header.Get("Accept"), "text/plain") {
+			ctx.SetHeader("Content-Type", "text/plain; charset=utf-8")
+			ctx.RespStatusCode = http.StatusOK
+			ctx.RespData = formatRoutes(routes)
+			return
+		}
+		if err := ctx.RespJSONOK(routes); err != nil {
+			ctx.RespStatusCode = http.StatusInternalServerError
+			ctx.RespData = []byte(err.Error())
+		}
+	}
+}
+
+func formatRoutes(routes []RouteInfo) []byte {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "METHOD\tPATTERN\tHANDLER\tMIDDLEWARES")
+	for _, r := range routes {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", r.Method, r.Pattern, r.Handler, r.Middlewares)
+	}
+	_ = w.Flush()
+	return buf.Bytes()
+}
+
+func (r *router) routes() []RouteInfo {
+	var res []RouteInfo
+	for method, root := range r.trees {
+		res = root.routes(method, res)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Pattern != res[j].Pattern {
+			return res[i].Pattern < res[j].Pattern
+		}
+		return res[i].Method < res[j].Method
+	})
+	return res
+}
+
+// routes 深度遍历, 收集有 handler 的节点
+func (n *node) routes(method string, res []RouteInfo) []RouteInfo {
+	if n.handler != nil {
+		res = append(res, RouteInfo{
+			Method:      method,
+			Pattern:     n.route,
+			Handler:     n.handlerName,
+			Middlewares: len(n.mdls),
+		})
+	}
+	for _, child := range n.chlidren {
+		res = child.routes(method, res)
+	}
+	for _, child := range []*node{n.regChild, n.paramChild, n.starChild} {
+		if child != nil {
+			res = child.routes(method, res)
+		}
+	}
+	return res
+}
+
+// funcName 注册路由的时候算好, 存在节点上
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "unknown"
+	}
+	return f.Name()
+}
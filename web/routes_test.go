@@ -0,0 +1,62 @@
+package web
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func mockRouteHandler(ctx *Context) {}
+
+func mockRouteErrHandler(ctx *Context) error {
+	return nil
+}
+
+func TestHTTPServer_Routes(t *testing.T) {
+	mdl := func(next HandleFunc) HandleFunc {
+		return next
+	}
+	h := NewHTTPServer()
+	h.Get("/", mockRouteHandler)
+	h.Get("/user/:id", mockRouteHandler, mdl)
+	h.Post("/user/:id", mockRouteHandler)
+	admin := h.Group("/admin", mdl)
+	admin.Get("/order/:id(^[0-9]+$)", mockRouteHandler, mdl)
+	admin.Get("/static/*", mockRouteHandler)
+	h.Get("/debug/routes", h.RoutesHandler())
+	h.HandleErr(http.MethodPut, "/user/:id", mockRouteErrHandler)
+
+	name := "my-frame/web.mockRouteHandler"
+	wantRoutes := []RouteInfo{
+		{Method: http.MethodGet, Pattern: "/", Handler: name},
+		{Method: http.MethodGet, Pattern: "/admin/order/:id(^[0-9]+$)", Handler: name, Middlewares: 2},
+		{Method: http.MethodGet, Pattern: "/admin/static/*", Handler: name, Middlewares: 1},
+		{Method: http.MethodGet, Pattern: "/debug/routes", Handler: "my-frame/web.(*HTTPServer).RoutesHandler.func1"},
+		{Method: http.MethodGet, Pattern: "/user/:id", Handler: name, Middlewares: 1},
+		{Method: http.MethodPost, Pattern: "/user/:id", Handler: name},
+		// HandleErr 注册的显示原始的 handler
+		{Method: http.MethodPut, Pattern: "/user/:id", Handler: "my-frame/web.mockRouteErrHandler"},
+	}
+	assert.Equal(t, wantRoutes, h.Routes())
+
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/routes", nil))
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	var routes []RouteInfo
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &routes))
+	assert.Equal(t, wantRoutes, routes)
+
+	resp = httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/debug/routes?format=text", nil))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Body.String(), "METHOD  PATTERN")
+	assert.Contains(t, resp.Body.String(), "POST    /user/:id")
+
+	// 线程安全的路由树也一样
+	h = NewHTTPServer(ServerWithSafeRouter())
+	h.Get("/user/:id", mockRouteHandler)
+	assert.Equal(t, []RouteInfo{{Method: http.MethodGet, Pattern: "/user/:id", Handler: name}}, h.Routes())
+}
@@ -0,0 +1,64 @@
+package web
+
+import "sync"
+
+// safeRouter 线程安全的路由树
+// 装饰器模式, 用读写锁把 router 包起来
+// 查找加读锁, 注册和删除加写锁, 适用于读多写少的运行期动态注册路由的场景
+type safeRouter struct {
+	mutex sync.RWMutex
+	r     router
+}
+
+var _ routeTable = &safeRouter{}
+
+func newSafeRouter() *safeRouter {
+	return &safeRouter{
+		r: newRouter(),
+	}
+}
+
+func (s *safeRouter) addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.r.addRoute(method, path, handleFunc, mdls...)
+}
+
+func (s *safeRouter) addNamedRoute(method string, path string, handlerName string, handleFunc HandleFunc, mdls ...Middleware) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.r.addNamedRoute(method, path, handlerName, handleFunc, mdls...)
+}
+
+// findRoute 返回的是节点的副本
+// 释放读锁之后, 别的 goroutine 可能会修改这个节点, 例如删除了这个路由
+// 用副本保证本次请求看到的 handler, route 和 mdls 是一致的
+func (s *safeRouter) findRoute(method string, path string) (*matchInfo, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	info, ok := s.r.findRoute(method, path)
+	if !ok {
+		return nil, false
+	}
+	n := *info.n
+	info.n = &n
+	return info, true
+}
+
+func (s *safeRouter) removeRoute(method string, path string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.r.removeRoute(method, path)
+}
+
+func (s *safeRouter) allowedMethods(path string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.r.allowedMethods(path)
+}
+
+func (s *safeRouter) routes() []RouteInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.r.routes()
+}
@@ -0,0 +1,82 @@
+package web
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// 用 go test -race 运行
+func TestSafeRouter_Concurrent(t *testing.T) {
+	r := newSafeRouter()
+	var mockHandler HandleFunc = func(ctx *Context) {}
+	r.addRoute(http.MethodGet, "/user/:id", mockHandler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		path := fmt.Sprintf("/plugin/%d/home", i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				info, ok := r.findRoute(http.MethodGet, "/user/123")
+				if assert.True(t, ok) {
+					assert.NotNil(t, info.n.handler)
+				}
+				r.findRoute(http.MethodGet, path)
+				r.allowedMethods(path)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			r.addRoute(http.MethodGet, path, mockHandler)
+		}()
+		go func() {
+			defer wg.Done()
+			r.addRoute(http.MethodPost, path, mockHandler)
+			r.removeRoute(http.MethodPost, path)
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprintf("/plugin/%d/home", i)
+		info, ok := r.findRoute(http.MethodGet, path)
+		assert.True(t, ok)
+		assert.Equal(t, path, info.n.route)
+		_, ok = r.findRoute(http.MethodPost, path)
+		assert.False(t, ok)
+	}
+}
+
+func TestHTTPServer_RemoveRoute(t *testing.T) {
+	server := NewHTTPServer(ServerWithSafeRouter())
+	server.Get("/plugin/a", func(ctx *Context) {
+		ctx.RespData = []byte("plugin a")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/plugin/a", nil)
+			server.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+		path := fmt.Sprintf("/plugin/b%d", i)
+		go func() {
+			defer wg.Done()
+			server.Get(path, func(ctx *Context) {})
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, server.RemoveRoute(http.MethodGet, "/plugin/a"))
+	assert.False(t, server.RemoveRoute(http.MethodGet, "/plugin/a"))
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/plugin/a", nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+}
@@ -1,9 +1,15 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Server 对于特性上来说:
@@ -14,6 +20,10 @@ import (
 
 type HandleFunc func(ctx *Context)
 
+// Hook 生命周期回调
+// 例如启动之后往注册中心注册自己, 关闭之前从注册中心摘掉自己
+type Hook func(ctx context.Context) error
+
 // 接口实现校验
 var _ Server = &HTTPServer{}
 
@@ -25,7 +35,8 @@ type Server interface {
 	// method 是 HTTP 方法
 	// path 是 路由
 	// handleFunc 是 业务逻辑
-	addRoute(method string, path string, handleFunc HandleFunc)
+	// mdls 是只作用在这个路由上的 middleware
+	addRoute(method string, path string, handleFunc HandleFunc, mdls ...Middleware)
 
 	// AddRoute1 这种提供多个(没必要)
 	//AddRoute1(method string, path string, handle ...HandleFunc)
@@ -40,16 +51,49 @@ type HTTPServerOption func(server *HTTPServer)
 type HTTPServer struct {
 	// addr string 创建的时候传递, 而不是Start接收  这个都是可以的
 	//router
-	router
+	// 默认是 *router, 可以通过 ServerWithSafeRouter 换成线程安全的实现
+	routeTable
 	//r *router
 	mdls []Middleware
 
 	log func(msg string, args ...any)
 
 	tplEngine TemplateEngine
+	// 统一处理 handler 返回的 error
+	errHandler ErrorHandler
+	// 错误页面的模板
+	errTplName string
+
+	// 按照 Content-Type 序列化和反序列化, Respond 和 Bind 都用它
+	codecs *codecRegistry
+
+	// 请求 body 的大小限制, 小于等于 0 代表不限制
+	maxBodySize int64
+
+	// 复用 Context, 减少每个请求的内存分配
+	ctxPool sync.Pool
+	// 组装好的 middleware 链条, 第一个请求进来的时候组装
+	root      HandleFunc
+	chainOnce sync.Once
+
+	// 路由名字到路由的映射, 用于根据名字生成 URL
+	// 里面是 map[string]*urlPattern, 只读, 修改的时候复制一份再替换
+	// 这样 ServerWithSafeRouter 运行期 RemoveRoute 的时候, URLFor 不需要加锁
+	names atomic.Value
+	// 保证同一时间只有一个修改 names 的
+	namesMu sync.Mutex
 
 	//Middleware []Middleware
 
+	// 真正负责监听和处理连接的是 http.Server
+	// 优雅退出也是依赖它的 Shutdown 方法
+	server *http.Server
+
+	// 生命周期回调, 按照注册的顺序执行
+	onStart        []Hook
+	afterStart     []Hook
+	beforeShutdown []Hook
+	afterShutdown  []Hook
 }
 
 //func NewHTTPServerV1(mdls ...Middleware) *HTTPServer {
@@ -66,12 +110,22 @@ type HTTPServer struct {
 //}
 
 func NewHTTPServer(opts ...HTTPServerOption) *HTTPServer {
+	r := newRouter()
 	res := &HTTPServer{
-		router: newRouter(),
+		routeTable: &r,
+		codecs:     defaultCodecRegistry.clone(),
 		log: func(msg string, args ...any) {
 			fmt.Printf(msg, args...)
 		},
 	}
+	res.ctxPool.New = func() any {
+		return &Context{}
+	}
+	// 在这里就创建好, 这样 Shutdown 和 Start 在不同的 goroutine 里面调用也不会有问题
+	res.server = &http.Server{
+		Handler: res,
+	}
+	res.errHandler = res.handleError
 	for _, opt := range opts {
 		opt(res)
 	}
@@ -84,6 +138,15 @@ func ServerWithTemplateEngine(tpl TemplateEngine) HTTPServerOption {
 	}
 }
 
+// ServerWithSafeRouter 使用线程安全的路由树
+// 需要在运行期间注册或者删除路由的时候使用, 查找的时候要加读锁, 性能会差一点
+// 要放在注册路由之前, 因为会替换掉原本的路由树
+func ServerWithSafeRouter() HTTPServerOption {
+	return func(server *HTTPServer) {
+		server.routeTable = newSafeRouter()
+	}
+}
+
 func ServerWithMiddleware(mdls ...Middleware) HTTPServerOption {
 	return func(server *HTTPServer) {
 		server.mdls = mdls
@@ -97,14 +160,32 @@ func ServerWithMiddleware(mdls ...Middleware) HTTPServerOption {
 // 执行业务逻辑
 func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	// 你的框架代码就在这里
-	ctx := &Context{
-		Req:       request,
-		Resp:      writer,
-		tplEngine: h.tplEngine,
-	}
+	// Context 是从 pool 里面拿的, 请求结束之后放回去
+	// 所以 handler 返回之后就不能再持有 ctx 了, 例如不能在别的 goroutine 里面继续使用
+	ctx := h.ctxPool.Get().(*Context)
+	ctx.init(writer, request)
+	ctx.tplEngine = h.tplEngine
+	ctx.codecs = h.codecs
+	ctx.errHandler = h.errHandler
+	ctx.body = request.Body
+	ctx.maxBodySize = h.maxBodySize
+	request.Body = limitBody(writer, request.Body, h.maxBodySize)
+	defer func() {
+		// 先关闭流, 不然 handler 留下的 goroutine 会写到 reset 之后的 Context 上
+		ctx.closeStreams()
+		ctx.reset()
+		h.ctxPool.Put(ctx)
+	}()
 
 	// 接下来就是查找路由，并且执行命中的业务逻辑
+	h.chainOnce.Do(h.buildChain)
+	h.root(ctx)
+	//h.serve(ctx)
+}
 
+// buildChain 组装 middleware 链条, 只在第一个请求进来的时候执行一次
+// 所以 middleware 要在服务器开始处理请求之前设置好
+func (h *HTTPServer) buildChain() {
 	// 最后一个是这个
 	root := h.serve
 
@@ -127,15 +208,38 @@ func (h *HTTPServer) ServeHTTP(writer http.ResponseWriter, request *http.Request
 		}
 	}
 
-	root = m(root)
-
-	root(ctx)
-	//h.serve(ctx)
+	h.root = m(root)
 }
 
 func (h *HTTPServer) flashResp(ctx *Context) {
-	if ctx.RespStatusCode != 0 {
-		ctx.Resp.WriteHeader(ctx.RespStatusCode)
+	// 用户直接操作了 ctx.Resp, 例如 http.ServeFile, 那么响应已经写出去了
+	// 再写一遍就会出现 superfluous WriteHeader 或者 body 重复
+	if w, ok := ctx.Resp.(*responseWriter); ok && w.written {
+		return
+	}
+
+	header := ctx.Resp.Header()
+	for key, vals := range ctx.RespHeader {
+		header[key] = vals
+	}
+
+	status := ctx.RespStatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	hasBody := bodyAllowedForStatus(status)
+	if hasBody && len(ctx.RespData) > 0 {
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", http.DetectContentType(ctx.RespData))
+		}
+		// HEAD 请求也要带上, 和 GET 的响应保持一致
+		header.Set("Content-Length", strconv.Itoa(len(ctx.RespData)))
+	}
+	ctx.Resp.WriteHeader(status)
+
+	// HEAD 请求不需要 body, 204 和 304 不能有 body
+	if !hasBody || ctx.Req.Method == http.MethodHead {
+		return
 	}
 
 	n, err := ctx.Resp.Write(ctx.RespData)
@@ -147,8 +251,25 @@ func (h *HTTPServer) flashResp(ctx *Context) {
 func (h *HTTPServer) serve(ctx *Context) {
 	// before route
 	info, ok := h.findRoute(ctx.Req.Method, ctx.Req.URL.Path)
+	if (!ok || info.n.handler == nil) && ctx.Req.Method == http.MethodHead {
+		// 没有注册 HEAD 的话, 就用 GET 的路由来处理, 在 flashResp 里面不写 body
+		info, ok = h.findRoute(http.MethodGet, ctx.Req.URL.Path)
+	}
 	// after route
 	if !ok || info.n.handler == nil {
+		// 路径在别的 HTTP 方法下面注册过, 那么就是 405 而不是 404
+		allowed := h.allowedMethods(ctx.Req.URL.Path)
+		if len(allowed) > 0 {
+			ctx.SetHeader("Allow", strings.Join(allowed, ", "))
+			if ctx.Req.Method == http.MethodOptions {
+				// 没有注册 OPTIONS 的话, 直接告诉客户端支持哪些方法
+				ctx.RespStatusCode = http.StatusNoContent
+				return
+			}
+			ctx.RespStatusCode = http.StatusMethodNotAllowed
+			ctx.RespData = []byte("METHOD NOT ALLOWED")
+			return
+		}
 		// 路由没有命中, 就是 404
 		ctx.RespStatusCode = 404
 		ctx.RespData = []byte("NOT FOUND")
@@ -156,25 +277,102 @@ func (h *HTTPServer) serve(ctx *Context) {
 	}
 	ctx.PathParams = info.pathParams
 	ctx.MatchedRoute = info.n.route
+
+	// 路由上的 middleware 在命中路由之后才执行
+	// 所以这里能拿到 MatchedRoute 和 PathParams
+	// 链条在注册路由的时候就组装好了
 	// before execute
-	info.n.handler(ctx)
+	info.n.chain(ctx)
 	// after execute
 
 }
 
+// Start 启动服务器, 会一直阻塞直到服务器出错或者被 Shutdown
+// 被 Shutdown 的时候返回 nil
 func (h *HTTPServer) Start(addr string) error {
-	// 也可以自己创建Server
-	//http.Server{}
+	// 在监听端口之前, 执行一些你业务所需的前置条件
+	// 例如加载配置, 预热缓存
+	if err := h.runHooks(context.Background(), h.onStart); err != nil {
+		return err
+	}
+
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+	return h.Serve(l)
+}
 
+// Serve 在已有的 listener 上启动服务器, 不会执行 OnStart 回调
+func (h *HTTPServer) Serve(l net.Listener) error {
 	// 在这里, 可以让用户注册所谓的 after start 回调
 	// 比如: 往你的 admin 注册一下自己这个实例
-	// 在这里执行一些你业务所需的前置条件
+	// 端口已经监听了, 所以这个时候注册上去, 请求进来也能被处理
+	if err := h.runHooks(context.Background(), h.afterStart); err != nil {
+		_ = l.Close()
+		return err
+	}
+
+	err := h.server.Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown 优雅退出
+// 1. 执行 BeforeShutdown 回调, 例如从注册中心摘掉自己
+// 2. 不再接收新的连接, 等待正在处理的请求结束, 直到 ctx 超时
+// 3. 执行 AfterShutdown 回调, 例如释放资源
+// 中间某一步出错了也会继续往下执行, 最后把所有的错误一起返回
+func (h *HTTPServer) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, hook := range h.beforeShutdown {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := h.server.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, hook := range h.afterShutdown {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OnStart 注册在监听端口之前执行的回调, 出错了就不会启动
+func (h *HTTPServer) OnStart(hooks ...Hook) {
+	h.onStart = append(h.onStart, hooks...)
+}
+
+// AfterStart 注册在监听端口之后, 开始处理请求之前执行的回调, 出错了就不会启动
+func (h *HTTPServer) AfterStart(hooks ...Hook) {
+	h.afterStart = append(h.afterStart, hooks...)
+}
+
+// BeforeShutdown 注册在关闭服务器之前执行的回调
+func (h *HTTPServer) BeforeShutdown(hooks ...Hook) {
+	h.beforeShutdown = append(h.beforeShutdown, hooks...)
+}
 
-	return http.Serve(l, h)
+// AfterShutdown 注册在所有请求都处理完之后执行的回调
+func (h *HTTPServer) AfterShutdown(hooks ...Hook) {
+	h.afterShutdown = append(h.afterShutdown, hooks...)
+}
+
+// runHooks 按顺序执行, 遇到错误就中断
+func (h *HTTPServer) runHooks(ctx context.Context, hooks []Hook) error {
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 //func (h *HTTPServer) AddRoute(method string, path string, handleFunc HandleFunc) {
@@ -182,17 +380,54 @@ func (h *HTTPServer) Start(addr string) error {
 //	//panic("implement me")
 //}
 
-func (h *HTTPServer) Get(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodGet, path, handleFunc)
+func (h *HTTPServer) Get(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.addRoute(http.MethodGet, path, handleFunc, mdls...)
+}
+func (h *HTTPServer) Post(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.addRoute(http.MethodPost, path, handleFunc, mdls...)
+}
+func (h *HTTPServer) Put(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.addRoute(http.MethodPut, path, handleFunc, mdls...)
+}
+func (h *HTTPServer) Options(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.addRoute(http.MethodOptions, path, handleFunc, mdls...)
+}
+func (h *HTTPServer) Delete(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.addRoute(http.MethodDelete, path, handleFunc, mdls...)
 }
-func (h *HTTPServer) Post(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodPost, path, handleFunc)
+func (h *HTTPServer) Patch(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.addRoute(http.MethodPatch, path, handleFunc, mdls...)
 }
-func (h *HTTPServer) Put(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodPut, path, handleFunc)
+func (h *HTTPServer) Head(path string, handleFunc HandleFunc, mdls ...Middleware) {
+	h.addRoute(http.MethodHead, path, handleFunc, mdls...)
 }
-func (h *HTTPServer) Options(path string, handleFunc HandleFunc) {
-	h.addRoute(http.MethodOptions, path, handleFunc)
+
+// RemoveRoute 删除路由, path 要和注册的时候一模一样
+// 默认的路由树不是线程安全的, 在服务器运行期间调用要使用 ServerWithSafeRouter
+func (h *HTTPServer) RemoveRoute(method string, path string) bool {
+	if !h.removeRoute(method, path) {
+		return false
+	}
+	// 避免 URLFor 生成已经不存在的路由
+	h.removeNames(path)
+	return true
+}
+
+// Group 创建一个路由分组, prefix 下所有的路由都会执行 mdls
+func (h *HTTPServer) Group(prefix string, mdls ...Middleware) *RouteGroup {
+	return newRouteGroup(h, prefix, mdls)
+}
+
+// WrapHandler 把标准库的 http.Handler 转成 HandleFunc, 例如 promhttp.Handler()
+// handler 直接写 ctx.Resp, 所以 flashResp 不会再写 RespData
+// 写出去的响应码会同步到 RespStatusCode 上, 这样 middleware 能够看到
+func WrapHandler(handler http.Handler) HandleFunc {
+	return func(ctx *Context) {
+		handler.ServeHTTP(ctx.Resp, ctx.Req)
+		if w, ok := ctx.Resp.(*responseWriter); ok && w.status != 0 {
+			ctx.RespStatusCode = w.status
+		}
+	}
 }
 
 //func (h *HTTPServer) AddRoute1(method string, path string, handle ...HandleFunc) {
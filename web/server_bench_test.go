@@ -0,0 +1,84 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// BenchmarkHTTPServer_ServeHTTP 三个全局 middleware, route middleware 再加上三个路由上的 middleware
+// 每个请求都创建 Context 并且组装 middleware 链条的时候:
+//
+//	static     1199 ns/op    317 B/op    11 allocs/op
+//	param      1739 ns/op    656 B/op    13 allocs/op
+//	not found  1961 ns/op    376 B/op    13 allocs/op
+//
+// 链条只组装一次, Context 放到 sync.Pool 里面复用之后:
+//
+//	static      625 ns/op     69 B/op     4 allocs/op
+//	param      1276 ns/op    408 B/op     6 allocs/op
+//	not found  1081 ns/op    128 B/op     6 allocs/op
+//
+// 路由上的 middleware 每个请求都组装的时候:
+//
+//	route middleware   832 ns/op    117 B/op     7 allocs/op
+//
+// 注册路由的时候组装好之后:
+//
+//	route middleware   712 ns/op     69 B/op     4 allocs/op
+func BenchmarkHTTPServer_ServeHTTP(b *testing.B) {
+	mdl := func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+		}
+	}
+	server := NewHTTPServer(ServerWithMiddleware(mdl, mdl, mdl))
+	server.Get("/user/home", func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("hello")
+	})
+	server.Get("/user/:id", func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte(ctx.PathParams["id"])
+	})
+	server.Get("/admin/home", func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("hello")
+	}, mdl, mdl, mdl)
+
+	testCases := []struct {
+		name string
+		path string
+	}{
+		{name: "static", path: "/user/home"},
+		{name: "param", path: "/user/123"},
+		{name: "not found", path: "/order/123"},
+		{name: "route middleware", path: "/admin/home"},
+	}
+	for _, tc := range testCases {
+		b.Run(tc.name, func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			resp := &discardWriter{header: http.Header{}}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.ServeHTTP(resp, req)
+			}
+		})
+	}
+}
+
+// discardWriter 避免 httptest.ResponseRecorder 本身的内存分配影响结果
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (d *discardWriter) WriteHeader(statusCode int) {}
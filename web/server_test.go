@@ -1,9 +1,17 @@
 package web
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestHTTPServer_ServeHTTP(t *testing.T) {
@@ -34,5 +42,390 @@ func TestHTTPServer_ServeHTTP(t *testing.T) {
 			}
 		},
 	}
-	server.ServeHTTP(nil, &http.Request{})
+	server.ServeHTTP(httptest.NewRecorder(), &http.Request{})
+}
+
+func TestHTTPServer_Shutdown(t *testing.T) {
+	var logs []string
+	hookBuilder := func(name string) Hook {
+		return func(ctx context.Context) error {
+			logs = append(logs, name)
+			return nil
+		}
+	}
+
+	server := NewHTTPServer()
+	server.OnStart(hookBuilder("on start"))
+	server.AfterStart(hookBuilder("after start 1"), hookBuilder("after start 2"))
+	server.BeforeShutdown(hookBuilder("before shutdown"))
+	server.AfterShutdown(hookBuilder("after shutdown"))
+
+	handling := make(chan struct{})
+	server.Get("/slow", func(ctx *Context) {
+		close(handling)
+		time.Sleep(100 * time.Millisecond)
+		ctx.RespData = []byte("slow")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(l)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			respCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		respCh <- result{body: string(data), err: err}
+	}()
+
+	// 等请求进来了再关闭, 正在处理的请求要能够正常返回
+	<-handling
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(ctx))
+
+	res := <-respCh
+	require.NoError(t, res.err)
+	assert.Equal(t, "slow", res.body)
+	assert.NoError(t, <-serveErr)
+	// Serve 不会执行 OnStart
+	assert.Equal(t, []string{"after start 1", "after start 2", "before shutdown", "after shutdown"}, logs)
+}
+
+func TestHTTPServer_HookError(t *testing.T) {
+	hookErr := errors.New("hook error")
+
+	server := NewHTTPServer()
+	var called bool
+	server.OnStart(func(ctx context.Context) error {
+		return hookErr
+	}, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, hookErr, server.Start("127.0.0.1:0"))
+	// 前面的出错了, 后面的就不执行了
+	assert.False(t, called)
+
+	server = NewHTTPServer()
+	server.AfterStart(func(ctx context.Context) error {
+		return hookErr
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, hookErr, server.Serve(l))
+
+	// 关闭的时候出错了, 也要继续执行后面的回调
+	server = NewHTTPServer()
+	server.BeforeShutdown(func(ctx context.Context) error {
+		return hookErr
+	})
+	server.AfterShutdown(func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	err = server.Shutdown(context.Background())
+	assert.True(t, errors.Is(err, hookErr))
+	assert.True(t, called)
+}
+
+func TestHTTPServer_MethodNotAllowed(t *testing.T) {
+	server := NewHTTPServer()
+	server.Get("/user/:id", func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("get " + ctx.PathParams["id"])
+	})
+	server.Delete("/user/:id", func(ctx *Context) {})
+	server.Post("/order", func(ctx *Context) {})
+	server.Options("/order", func(ctx *Context) {
+		ctx.RespStatusCode = http.StatusOK
+		ctx.RespData = []byte("options")
+	})
+
+	testCases := []struct {
+		name      string
+		method    string
+		path      string
+		wantCode  int
+		wantAllow string
+		wantBody  string
+		wantRoute string
+	}{
+		{
+			name:      "method not allowed",
+			method:    http.MethodPut,
+			path:      "/user/12",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "DELETE, GET, HEAD, OPTIONS",
+			wantBody:  "METHOD NOT ALLOWED",
+		},
+		{
+			// 没有注册 OPTIONS, 自动应答
+			name:      "auto options",
+			method:    http.MethodOptions,
+			path:      "/user/12",
+			wantCode:  http.StatusNoContent,
+			wantAllow: "DELETE, GET, HEAD, OPTIONS",
+		},
+		{
+			// 注册了 OPTIONS 就用用户自己的
+			name:     "registered options",
+			method:   http.MethodOptions,
+			path:     "/order",
+			wantCode: http.StatusOK,
+			wantBody: "options",
+		},
+		{
+			name:      "no get no head",
+			method:    http.MethodGet,
+			path:      "/order",
+			wantCode:  http.StatusMethodNotAllowed,
+			wantAllow: "OPTIONS, POST",
+			wantBody:  "METHOD NOT ALLOWED",
+		},
+		{
+			// HEAD 用 GET 的路由处理, 但是不写 body
+			name:      "head",
+			method:    http.MethodHead,
+			path:      "/user/12",
+			wantCode:  http.StatusOK,
+			wantRoute: "/user/:id",
+		},
+		{
+			name:     "not found",
+			method:   http.MethodGet,
+			path:     "/abc",
+			wantCode: http.StatusNotFound,
+			wantBody: "NOT FOUND",
+		},
+	}
+
+	// middleware 链条只会组装一次, 所以要在第一个请求之前设置好
+	var route string
+	server.mdls = []Middleware{func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			route = ctx.MatchedRoute
+		}
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			route = ""
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantAllow, resp.Header().Get("Allow"))
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+			if tc.wantRoute != "" {
+				assert.Equal(t, tc.wantRoute, route)
+			}
+		})
+	}
+}
+
+func TestHTTPServer_FlashResp(t *testing.T) {
+	testCases := []struct {
+		name       string
+		method     string
+		handler    HandleFunc
+		mdl        Middleware
+		wantCode   int
+		wantHeader http.Header
+		wantBody   string
+	}{
+		{
+			name:   "json",
+			method: http.MethodGet,
+			handler: func(ctx *Context) {
+				_ = ctx.RespJSONOK(map[string]string{"name": "Tom"})
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type":   []string{"application/json"},
+				"Content-Length": []string{"14"},
+			},
+			wantBody: `{"name":"Tom"}`,
+		},
+		{
+			// 没有设置 Content-Type 的时候按照内容猜
+			name:   "detect content type",
+			method: http.MethodGet,
+			handler: func(ctx *Context) {
+				ctx.RespData = []byte("hello")
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type":   []string{"text/plain; charset=utf-8"},
+				"Content-Length": []string{"5"},
+			},
+			wantBody: "hello",
+		},
+		{
+			// middleware 在 next 之后还能改头部
+			name:   "middleware rewrite header",
+			method: http.MethodGet,
+			handler: func(ctx *Context) {
+				_ = ctx.RespJSONOK("Tom")
+			},
+			mdl: func(next HandleFunc) HandleFunc {
+				return func(ctx *Context) {
+					next(ctx)
+					ctx.SetHeader("Content-Type", "application/vnd.api+json")
+					ctx.SetHeader("X-Request-Id", "123")
+				}
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type":   []string{"application/vnd.api+json"},
+				"Content-Length": []string{"5"},
+				"X-Request-Id":   []string{"123"},
+			},
+			wantBody: `"Tom"`,
+		},
+		{
+			name:   "no content",
+			method: http.MethodGet,
+			handler: func(ctx *Context) {
+				ctx.RespStatusCode = http.StatusNoContent
+				ctx.RespData = []byte("hello")
+			},
+			wantCode:   http.StatusNoContent,
+			wantHeader: http.Header{},
+		},
+		{
+			name:   "not modified",
+			method: http.MethodGet,
+			handler: func(ctx *Context) {
+				ctx.RespStatusCode = http.StatusNotModified
+				ctx.RespData = []byte("hello")
+			},
+			wantCode:   http.StatusNotModified,
+			wantHeader: http.Header{},
+		},
+		{
+			// HEAD 不写 body, 但是头部和 GET 一样
+			name:   "head",
+			method: http.MethodHead,
+			handler: func(ctx *Context) {
+				ctx.RespData = []byte("hello")
+			},
+			wantCode: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type":   []string{"text/plain; charset=utf-8"},
+				"Content-Length": []string{"5"},
+			},
+		},
+		{
+			// 直接写了 ctx.Resp, 框架不会再写一遍
+			name:   "write directly",
+			method: http.MethodGet,
+			handler: func(ctx *Context) {
+				ctx.Resp.WriteHeader(http.StatusAccepted)
+				_, _ = ctx.Resp.Write([]byte("direct"))
+				ctx.RespStatusCode = http.StatusOK
+				ctx.RespData = []byte("hello")
+			},
+			wantCode:   http.StatusAccepted,
+			wantHeader: http.Header{},
+			wantBody:   "direct",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []HTTPServerOption
+			if tc.mdl != nil {
+				opts = append(opts, ServerWithMiddleware(tc.mdl))
+			}
+			server := NewHTTPServer(opts...)
+			server.Get("/user", tc.handler)
+			req := httptest.NewRequest(tc.method, "/user", nil)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantHeader, resp.Header())
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+		})
+	}
+}
+
+func TestHTTPServer_ContextPool(t *testing.T) {
+	server := NewHTTPServer()
+	server.Get("/user/:id", func(ctx *Context) {
+		// 上一个请求留下来的数据都应该被清空
+		assert.Empty(t, ctx.UserValues)
+		assert.Empty(t, ctx.RespHeader)
+		assert.Nil(t, ctx.RespData)
+		assert.Equal(t, 0, ctx.RespStatusCode)
+		assert.Equal(t, map[string]string{"id": ctx.Req.URL.Query().Get("id")}, ctx.PathParams)
+		name, err := ctx.QueryValue("name")
+		require.NoError(t, err)
+
+		if ctx.UserValues == nil {
+			ctx.UserValues = map[string]any{}
+		}
+		ctx.UserValues["name"] = name
+		ctx.SetHeader("X-Name", name)
+		ctx.RespData = []byte(name)
+	})
+
+	for _, name := range []string{"Tom", "Jerry", "Alice"} {
+		req := httptest.NewRequest(http.MethodGet, "/user/"+name+"?id="+name+"&name="+name, nil)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		assert.Equal(t, name, resp.Body.String())
+		assert.Equal(t, []string{name}, resp.Header().Values("X-Name"))
+	}
+}
+
+func TestWrapHandler(t *testing.T) {
+	var status int
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			status = ctx.RespStatusCode
+		}
+	}))
+	server.Get("/std", WrapHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("std " + r.URL.Path))
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/std", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+	assert.Equal(t, "std /std", resp.Body.String())
+	assert.Equal(t, http.StatusAccepted, status)
+}
+
+func TestHTTPServer_RouteChain(t *testing.T) {
+	built, called := 0, 0
+	mdl := func(next HandleFunc) HandleFunc {
+		built++
+		return func(ctx *Context) {
+			called++
+			next(ctx)
+		}
+	}
+	h := NewHTTPServer()
+	h.Group("/admin", mdl).Get("/user", func(ctx *Context) {}, mdl)
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/user", nil))
+	}
+	// 注册的时候组装一次, 每个请求都会执行
+	assert.Equal(t, 2, built)
+	assert.Equal(t, 6, called)
 }
@@ -3,6 +3,7 @@ package session
 import (
 	"github.com/google/uuid"
 	"my-frame/web"
+	"sync"
 )
 
 type Manage struct {
@@ -10,17 +11,33 @@ type Manage struct {
 
 	Store
 
+	// CtxSessKey GetSession 和 InitSession 会把 session 放到 ctx.UserValues[CtxSessKey] 里面
+	// 新的代码建议用 SessionKey, 不需要类型断言, 并且 Req.Context() 也能拿到
+	// 同时也是 SessionKey 的名字, 默认是 session
 	CtxSessKey string
+
+	keyOnce sync.Once
+	key     *web.Key[Session]
+}
+
+// SessionKey GetSession 会把 session 缓存在这里
+// 每个 Manage 都有自己的 key, 例如后台和前台用两个 Manage 的时候, 同一个请求里面不会互相覆盖
+// 只能拿到 context.Context 的代码可以用 m.SessionKey().FromContext 拿到 session
+func (m *Manage) SessionKey() *web.Key[Session] {
+	m.keyOnce.Do(func() {
+		name := m.CtxSessKey
+		if name == "" {
+			name = "session"
+		}
+		m.key = web.NewKey[Session](name)
+	})
+	return m.key
 }
 
 func (m *Manage) GetSession(ctx *web.Context) (Session, error) {
-	if ctx.UserValues == nil {
-		ctx.UserValues = make(map[string]any, 1)
-	}
 	//ctx.Req.Context().Value(m.CtxSessKey)
-	val, ok := ctx.UserValues[m.CtxSessKey]
-	if ok {
-		return val.(Session), nil
+	if sess, ok := m.SessionKey().Get(ctx); ok {
+		return sess, nil
 	}
 	// 尝试缓存住 session
 	sessId, err := m.Extract(ctx.Req)
@@ -32,7 +49,7 @@ func (m *Manage) GetSession(ctx *web.Context) (Session, error) {
 	if err != nil {
 		return nil, err
 	}
-	ctx.UserValues[m.CtxSessKey] = sess
+	m.cache(ctx, sess)
 	//ctx.Req = ctx.Req.WithContext(context.WithValue(ctx.Req.Context(), m.CtxSessKey, sess))  // 复制问题 影响性能  还有 因为context.Context的一个特性
 	return sess, err
 
@@ -46,7 +63,21 @@ func (m *Manage) InitSession(ctx *web.Context) (Session, error) {
 	}
 	// 注入进去 HTTP 响应里面
 	err = m.Inject(id, ctx.Resp)
-	return sess, err
+	if err != nil {
+		return nil, err
+	}
+	// 同一个请求里面后面的 GetSession 能直接拿到
+	m.cache(ctx, sess)
+	return sess, nil
+}
+
+// cache 同时放到 SessionKey 和 UserValues 里面, 兼容直接读 ctx.UserValues[m.CtxSessKey] 的代码
+func (m *Manage) cache(ctx *web.Context, sess Session) {
+	m.SessionKey().Set(ctx, sess)
+	if ctx.UserValues == nil {
+		ctx.UserValues = make(map[string]any, 1)
+	}
+	ctx.UserValues[m.CtxSessKey] = sess
 }
 
 func (m *Manage) RefreshSession(ctx *web.Context) error {
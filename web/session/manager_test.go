@@ -0,0 +1,102 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"my-frame/web"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestManage_SessionKey(t *testing.T) {
+	// 后台和前台各用一个 Manage
+	admin := &Manage{
+		Propagator: &mockPropagator{},
+		Store:      &mockStore{},
+		CtxSessKey: "admin_session",
+	}
+	user := &Manage{
+		Propagator: &mockPropagator{},
+		Store:      &mockStore{},
+	}
+	assert.NotSame(t, admin.SessionKey(), user.SessionKey())
+	assert.Same(t, admin.SessionKey(), admin.SessionKey())
+	assert.Equal(t, "admin_session", admin.SessionKey().String())
+	assert.Equal(t, "session", user.SessionKey().String())
+
+	ctx := &web.Context{
+		Req:  httptest.NewRequest(http.MethodGet, "/", nil),
+		Resp: httptest.NewRecorder(),
+	}
+	adminSess, err := admin.InitSession(ctx)
+	require.NoError(t, err)
+	userSess, err := user.InitSession(ctx)
+	require.NoError(t, err)
+
+	// 同一个请求里面互不覆盖
+	sess, err := admin.GetSession(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, adminSess.ID(), sess.ID())
+	sess, err = user.GetSession(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, userSess.ID(), sess.ID())
+
+	sess, ok := user.SessionKey().FromContext(ctx.Req.Context())
+	require.True(t, ok)
+	assert.Equal(t, userSess.ID(), sess.ID())
+
+	// 以前的代码直接读 UserValues
+	assert.Equal(t, adminSess, ctx.UserValues["admin_session"])
+	assert.Equal(t, userSess, ctx.UserValues[""])
+}
+
+type mockPropagator struct{}
+
+func (p *mockPropagator) Inject(id string, writer http.ResponseWriter) error {
+	return nil
+}
+
+func (p *mockPropagator) Extract(req *http.Request) (string, error) {
+	return "", errors.New("session: 没有 session id")
+}
+
+func (p *mockPropagator) Remove(writer http.ResponseWriter) error {
+	return nil
+}
+
+type mockStore struct{}
+
+func (s *mockStore) Generate(ctx context.Context, id string) (Session, error) {
+	return &mockSession{id: id}, nil
+}
+
+func (s *mockStore) Refresh(ctx context.Context, id string) error {
+	return nil
+}
+
+func (s *mockStore) Remove(ctx context.Context, id string) error {
+	return nil
+}
+
+func (s *mockStore) Get(ctx context.Context, id string) (Session, error) {
+	return nil, errors.New("session: 找不到 session")
+}
+
+type mockSession struct {
+	id string
+}
+
+func (s *mockSession) Get(ctx context.Context, key string) (any, error) {
+	return nil, errors.New("session: 找不到 key")
+}
+
+func (s *mockSession) Set(ctx context.Context, key string, val any) error {
+	return nil
+}
+
+func (s *mockSession) ID() string {
+	return s.id
+}
@@ -0,0 +1,237 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RespSize 响应 body 的字节数, middleware 统计流量用
+// 流式响应或者直接写了 Resp 的, 是已经写出去的字节数; 否则就是 RespData 的长度
+func (c *Context) RespSize() int {
+	if w, ok := c.Resp.(*responseWriter); ok && w.written {
+		return w.size
+	}
+	return len(c.RespData)
+}
+
+// Stream 流式响应, 例如导出大文件, fn 里面每次 Write 之后都会 Flush
+// 状态码用 RespStatusCode, 没有设置就是 200, 头部用 RespHeader, 所以要在调用之前设置好
+// 没有 Content-Length, 所以 HTTP/1.1 下面会使用 chunked 编码
+// 客户端断开之后 Write 会返回 ctx.Req.Context().Err()
+//
+// 调用了 Stream 之后 RespData 就没用了, flashResp 不会再写响应
+// 但是 middleware 依旧能够通过 RespStatusCode 和 RespSize 拿到状态码和字节数
+// 请求结束之后 w 会被关闭, 再 Write 会返回 error
+func (c *Context) Stream(fn func(w io.Writer) error) error {
+	return fn(c.startStream())
+}
+
+func (c *Context) startStream() *streamWriter {
+	header := c.Resp.Header()
+	for key, vals := range c.RespHeader {
+		header[key] = vals
+	}
+	// 不知道最终有多长
+	header.Del("Content-Length")
+	if c.RespStatusCode == 0 {
+		c.RespStatusCode = http.StatusOK
+	}
+	c.Resp.WriteHeader(c.RespStatusCode)
+	// Context 会被复用, 所以不能持有 ctx, 只持有这个请求的 Resp 和 Req.Context()
+	w := &streamWriter{resp: c.Resp, reqCtx: c.Req.Context()}
+	c.streamClosers = append(c.streamClosers, w.close)
+	// 先把头部发出去, 客户端不用等到第一份数据
+	w.flush()
+	return w
+}
+
+var (
+	errStreamClosed      = errors.New("web: 请求已经结束, 不能再写响应")
+	errHeartbeatInterval = errors.New("web: 心跳间隔必须大于 0")
+)
+
+type streamWriter struct {
+	mu     sync.Mutex
+	resp   http.ResponseWriter
+	reqCtx context.Context
+	closed bool
+}
+
+func (w *streamWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, errStreamClosed
+	}
+	if err := w.reqCtx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.resp.Write(data)
+	if err != nil {
+		return n, err
+	}
+	w.flush()
+	return n, nil
+}
+
+func (w *streamWriter) flush() {
+	if f, ok := w.resp.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close 请求结束的时候由框架调用, 之后 Resp 就不能再用了
+func (w *streamWriter) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+}
+
+// closeStreams 关闭这个请求上打开的流, 在 Context 放回 pool 之前调用
+// handler 忘了调用 SSEWriter.Close 的时候, 心跳的 goroutine 也会在这里退出
+func (c *Context) closeStreams() {
+	for _, closeFn := range c.streamClosers {
+		closeFn()
+	}
+	c.streamClosers = nil
+}
+
+// SSE 开启 Server-Sent Events 响应, 例如
+//
+//	sse := ctx.SSE()
+//	defer sse.Close()
+//	sse.Heartbeat(15 * time.Second)
+//	for {
+//		select {
+//		case msg := <-msgs:
+//			if err := sse.Send("message", msg); err != nil {
+//				return
+//			}
+//		case <-sse.Done():
+//			return
+//		}
+//	}
+func (c *Context) SSE() *SSEWriter {
+	c.SetHeader("Content-Type", "text/event-stream")
+	c.SetHeader("Cache-Control", "no-cache")
+	c.SetHeader("Connection", "keep-alive")
+	// 避免 nginx 之类的代理缓存响应
+	c.SetHeader("X-Accel-Buffering", "no")
+	sse := &SSEWriter{
+		w:     c.startStream(),
+		done:  c.Req.Context().Done(),
+		close: make(chan struct{}),
+	}
+	// 在 startStream 注册的 close 之前执行, 先停掉心跳
+	c.streamClosers = append([]func(){sse.Close}, c.streamClosers...)
+	return sse
+}
+
+// SSEWriter 发送事件, 可以在多个 goroutine 里面使用
+// handler 返回之后框架会调用 Close, 之后再 Send 会返回 error
+type SSEWriter struct {
+	mu sync.Mutex
+	w  *streamWriter
+	// 客户端断开连接的时候会被关闭
+	done <-chan struct{}
+
+	close     chan struct{}
+	closeOnce sync.Once
+	heartbeat sync.WaitGroup
+}
+
+// Done 客户端断开连接的时候会被关闭
+func (s *SSEWriter) Done() <-chan struct{} {
+	return s.done
+}
+
+// Send 发送一个事件, event 为空的时候客户端按照 message 处理
+// data 是 string 或者 []byte 的时候原样发送, 否则序列化成 JSON
+// 多行的 data 会被拆成多个 data 字段
+func (s *SSEWriter) Send(event string, data any) error {
+	var payload []byte
+	switch val := data.(type) {
+	case string:
+		payload = []byte(val)
+	case []byte:
+		payload = val
+	default:
+		var err error
+		if payload, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(event)
+		buf.WriteByte('\n')
+	}
+	for _, line := range bytes.Split(payload, []byte{'\n'}) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+// Heartbeat 每隔 interval 发送一个注释行, 避免连接被代理当成空闲连接断开
+// 客户端断开或者调用了 Close 之后停止
+func (s *SSEWriter) Heartbeat(interval time.Duration) error {
+	if interval <= 0 {
+		return errHeartbeatInterval
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.close:
+		return errStreamClosed
+	default:
+	}
+	s.heartbeat.Add(1)
+	go func() {
+		defer s.heartbeat.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if s.write([]byte(": ping\n\n")) != nil {
+					return
+				}
+			case <-s.done:
+				return
+			case <-s.close:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close 停止心跳, 并且等心跳的 goroutine 退出
+// 可以重复调用, handler 里面没有调用的话, 请求结束的时候框架会调用
+func (s *SSEWriter) Close() {
+	s.closeOnce.Do(func() {
+		// 和 Heartbeat 互斥, 避免 Wait 的同时又 Add
+		s.mu.Lock()
+		close(s.close)
+		s.mu.Unlock()
+	})
+	s.heartbeat.Wait()
+}
+
+func (s *SSEWriter) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(data)
+	return err
+}
@@ -0,0 +1,150 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContext_Stream(t *testing.T) {
+	var (
+		status int
+		size   int
+	)
+	server := NewHTTPServer(ServerWithMiddleware(func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			status = ctx.RespStatusCode
+			size = ctx.RespSize()
+		}
+	}))
+	server.Get("/export", func(ctx *Context) {
+		ctx.SetHeader("Content-Type", "text/csv")
+		ctx.RespStatusCode = http.StatusCreated
+		err := ctx.Stream(func(w io.Writer) error {
+			for i := 0; i < 3; i++ {
+				if _, err := fmt.Fprintf(w, "%d,line\n", i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		// 已经开始流式响应了, 这里设置的不会生效
+		ctx.RespData = []byte("ignored")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/export", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.True(t, resp.Flushed)
+	assert.Equal(t, "text/csv", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "0,line\n1,line\n2,line\n", resp.Body.String())
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, 21, size)
+}
+
+func TestContext_StreamClientGone(t *testing.T) {
+	server := NewHTTPServer()
+	var err error
+	server.Get("/export", func(ctx *Context) {
+		err = ctx.Stream(func(w io.Writer) error {
+			_, err := w.Write([]byte("abc"))
+			return err
+		})
+	})
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/export", nil).WithContext(reqCtx)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, "", resp.Body.String())
+}
+
+func TestContext_SSE(t *testing.T) {
+	server := NewHTTPServer()
+	server.Get("/events", func(ctx *Context) {
+		sse := ctx.SSE()
+		defer sse.Close()
+		require.NoError(t, sse.Heartbeat(10*time.Millisecond))
+		require.NoError(t, sse.Send("", "hello"))
+		require.NoError(t, sse.Send("user", map[string]string{"name": "Tom"}))
+		require.NoError(t, sse.Send("multi", "a\nb"))
+		// 等客户端断开
+		<-sse.Done()
+		assert.Error(t, sse.Send("user", "after close"))
+	})
+	httpSrv := httptest.NewServer(server)
+	defer httpSrv.Close()
+
+	resp, err := http.Get(httpSrv.URL + "/events")
+	require.NoError(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	var lines []string
+	reader := bufio.NewReader(resp.Body)
+	// 三个事件和至少一个心跳, 心跳可能夹在事件中间, 心跳后面的空行跳过
+	for events, pings, ping := 0, 0, false; events < 3 || pings < 1; {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == ": ping":
+			pings++
+			ping = true
+		case line == "" && ping:
+			ping = false
+		default:
+			if line == "" {
+				events++
+			}
+			lines = append(lines, line)
+		}
+	}
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, []string{
+		"data: hello", "",
+		"event: user", `data: {"name":"Tom"}`, "",
+		"event: multi", "data: a", "data: b", "",
+	}, lines[:9])
+}
+
+func TestContext_SSENotClosed(t *testing.T) {
+	server := NewHTTPServer()
+	sseCh := make(chan *SSEWriter, 1)
+	server.Get("/events", func(ctx *Context) {
+		sse := ctx.SSE()
+		assert.Equal(t, errHeartbeatInterval, sse.Heartbeat(0))
+		require.NoError(t, sse.Heartbeat(time.Millisecond))
+		// 没有调用 Close 就返回了
+		sseCh <- sse
+	})
+	httpSrv := httptest.NewServer(server)
+	defer httpSrv.Close()
+
+	resp, err := http.Get(httpSrv.URL + "/events")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	sse := <-sseCh
+
+	// 框架已经关闭了流, 心跳也停了, 后续的请求会复用同一个 Context
+	for i := 0; i < 10; i++ {
+		resp, err := http.Get(httpSrv.URL + "/404")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, errStreamClosed, sse.Send("", "after return"))
+	assert.Equal(t, errStreamClosed, sse.Heartbeat(time.Millisecond))
+}
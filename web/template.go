@@ -3,9 +3,13 @@ package web
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"html/template"
 )
 
+var errNoTemplateEngine = errors.New("web: 没有设置 TemplateEngine")
+
 type TemplateEngine interface {
 	// Render 渲染页面
 	// tplName 模板的名字, 按名索引
@@ -24,10 +28,30 @@ type TemplateEngine interface {
 	//Render(ctx Context)
 }
 
+// GoTemplateEngine 基于 html/template 的实现
+// 要在模板里面使用 urlFor 之类的函数, 用 ServerWithGoTemplateEngine 创建
 type GoTemplateEngine struct {
 	T *template.Template
 }
 
+// ServerWithGoTemplateEngine 使用 GoTemplateEngine, 解析模板之前会先注册 HTTPServer.TemplateFuncs
+// 所以模板里面可以直接用 urlFor, 例如
+//
+//	h := NewHTTPServer(ServerWithGoTemplateEngine(func(t *template.Template) (*template.Template, error) {
+//		return t.ParseGlob("*.gohtml")
+//	}))
+//
+// urlFor 是渲染的时候才查找路由名字的, 所以路由可以在这之后再注册和命名
+func ServerWithGoTemplateEngine(parse func(t *template.Template) (*template.Template, error)) HTTPServerOption {
+	return func(server *HTTPServer) {
+		t, err := parse(template.New("").Funcs(server.TemplateFuncs()))
+		if err != nil {
+			panic(fmt.Sprintf("web: 解析模板失败: %v", err))
+		}
+		server.tplEngine = &GoTemplateEngine{T: t}
+	}
+}
+
 func (g *GoTemplateEngine) Render(ctx context.Context, tplName string, data any) ([]byte, error) {
 	bs := &bytes.Buffer{}
 	err := g.T.ExecuteTemplate(bs, tplName, data)
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// urlPattern 命名路由解析之后的结果
+// 在 Name 的时候就解析好, 生成 URL 的时候不需要再解析一遍
+type urlPattern struct {
+	route string
+	segs  []urlSeg
+}
+
+type urlSeg struct {
+	// 静态路由的值
+	static string
+	// 路径参数, 正则路由和通配符的参数名
+	paramName string
+	// 正则路由才有, 生成 URL 的时候校验参数
+	regExpr *regexp.Regexp
+	// 通配符可以匹配多段, 所以参数值里面的 / 不需要转义
+	isAny bool
+}
+
+// Name 给路由起一个名字, 之后可以用 URLFor 按照名字生成 URL
+// path 是注册时候的路由, 例如 /user/:id, 必须先注册路由再命名, 否则会 panic
+// 和注册路由一样, 要在启动服务器之前调用
+// 路由被 RemoveRoute 删掉之后, 名字也会失效
+func (h *HTTPServer) Name(name string, path string) {
+	if name == "" {
+		panic("web: 路由名字不能为空字符串")
+	}
+	h.namesMu.Lock()
+	defer h.namesMu.Unlock()
+	old := h.loadNames()
+	if _, ok := old[name]; ok {
+		panic(fmt.Sprintf("web: 路由名字冲突, 重复命名[%s]", name))
+	}
+	pattern := parseURLPattern(path)
+	if !h.hasRoute(path) {
+		panic(fmt.Sprintf("web: 路由 %s 没有注册, 不能命名为 %s", path, name))
+	}
+	names := make(map[string]*urlPattern, len(old)+1)
+	for key, val := range old {
+		names[key] = val
+	}
+	names[name] = pattern
+	h.names.Store(names)
+}
+
+// loadNames 拿到的 map 不能修改
+func (h *HTTPServer) loadNames() map[string]*urlPattern {
+	names, _ := h.names.Load().(map[string]*urlPattern)
+	return names
+}
+
+// hasRoute 任意一个 HTTP 方法下面注册了 path
+// 只在命名和删除路由的时候用, 不在意性能
+func (h *HTTPServer) hasRoute(path string) bool {
+	for _, r := range h.routes() {
+		if r.Pattern == path {
+			return true
+		}
+	}
+	return false
+}
+
+// removeNames 所有 HTTP 方法下面都没有 path 了, 删掉指向它的名字
+func (h *HTTPServer) removeNames(path string) {
+	h.namesMu.Lock()
+	defer h.namesMu.Unlock()
+	old := h.loadNames()
+	if len(old) == 0 || h.hasRoute(path) {
+		return
+	}
+	names := make(map[string]*urlPattern, len(old))
+	for name, pattern := range old {
+		if pattern.route != path {
+			names[name] = pattern
+		}
+	}
+	h.names.Store(names)
+}
+
+// Name 给分组下的路由起名字, path 不需要带上分组的前缀
+func (g *RouteGroup) Name(name string, path string) {
+	g.server.Name(name, g.fullPath(path))
+}
+
+// URLFor 根据路由名字生成 URL
+// params 是路径参数, 缺少参数或者参数不满足正则路由的时候返回 error
+// query 会被编码之后拼接在后面
+// 例如 h.URLFor("user.detail", map[string]string{"id": "12"}, url.Values{"tab": {"info"}})
+// 得到 /user/12?tab=info
+func (h *HTTPServer) URLFor(name string, params map[string]string, query url.Values) (string, error) {
+	pattern, ok := h.loadNames()[name]
+	if !ok {
+		return "", fmt.Errorf("web: 路由 %s 不存在", name)
+	}
+	path, err := pattern.build(params)
+	if err != nil {
+		return "", err
+	}
+	if len(query) > 0 {
+		path = path + "?" + query.Encode()
+	}
+	return path, nil
+}
+
+// TemplateFuncs 模板里面可以使用的函数
+// 要在解析模板之前通过 template.Funcs 注册进去
+//
+//	{{ urlFor "user.detail" "id" "12" "tab" "info" }}
+//
+// 参数是成对的 key value, 路由里面没有的 key 会作为查询参数
+func (h *HTTPServer) TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"urlFor": h.templateURLFor,
+	}
+}
+
+func (h *HTTPServer) templateURLFor(name string, kvs ...string) (string, error) {
+	if len(kvs)%2 != 0 {
+		return "", errors.New("web: urlFor 的参数必须是成对的 key value")
+	}
+	pattern, ok := h.loadNames()[name]
+	if !ok {
+		return "", fmt.Errorf("web: 路由 %s 不存在", name)
+	}
+	params := make(map[string]string, len(kvs)/2)
+	var query url.Values
+	for i := 0; i < len(kvs); i += 2 {
+		if pattern.hasParam(kvs[i]) {
+			params[kvs[i]] = kvs[i+1]
+			continue
+		}
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Add(kvs[i], kvs[i+1])
+	}
+	return h.URLFor(name, params, query)
+}
+
+// parseURLPattern 解析规则和 addRoute 保持一致
+func parseURLPattern(path string) *urlPattern {
+	if path == "" || path[0] != '/' {
+		panic("web: 路径必须以 / 开头")
+	}
+	res := &urlPattern{route: path}
+	if path == "/" {
+		return res
+	}
+	for _, seg := range strings.Split(path[1:], "/") {
+		if seg == "" {
+			panic("web: 不能有连续的 /")
+		}
+		switch seg[0] {
+		case ':':
+			paramName, expr, isReg := parseParam(seg)
+			us := urlSeg{paramName: paramName}
+			if isReg {
+				regExpr, err := regexp.Compile(expr)
+				if err != nil {
+					panic(fmt.Sprintf("web: 正则表达式错误 [%s]: %v", expr, err))
+				}
+				us.regExpr = regExpr
+			}
+			res.segs = append(res.segs, us)
+		case '*':
+			paramName := seg[1:]
+			if paramName == "" {
+				paramName = seg
+			}
+			res.segs = append(res.segs, urlSeg{paramName: paramName, isAny: true})
+		default:
+			res.segs = append(res.segs, urlSeg{static: seg})
+		}
+	}
+	return res
+}
+
+func (p *urlPattern) hasParam(name string) bool {
+	for _, seg := range p.segs {
+		if seg.paramName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *urlPattern) build(params map[string]string) (string, error) {
+	if len(p.segs) == 0 {
+		return "/", nil
+	}
+	var sb strings.Builder
+	for _, seg := range p.segs {
+		sb.WriteByte('/')
+		if seg.paramName == "" {
+			sb.WriteString(seg.static)
+			continue
+		}
+		val, ok := params[seg.paramName]
+		if !ok || val == "" {
+			return "", fmt.Errorf("web: 路由 %s 缺少路径参数 %s", p.route, seg.paramName)
+		}
+		if seg.regExpr != nil && !seg.regExpr.MatchString(val) {
+			return "", fmt.Errorf("web: 路由 %s 的路径参数 %s 不满足正则 %s", p.route, seg.paramName, seg.regExpr.String())
+		}
+		if !seg.isAny {
+			sb.WriteString(url.PathEscape(val))
+			continue
+		}
+		parts := strings.Split(strings.Trim(val, "/"), "/")
+		for i, part := range parts {
+			parts[i] = url.PathEscape(part)
+		}
+		sb.WriteString(strings.Join(parts, "/"))
+	}
+	return sb.String(), nil
+}
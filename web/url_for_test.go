@@ -0,0 +1,182 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHTTPServer_URLFor(t *testing.T) {
+	h := NewHTTPServer()
+	mockHandler := func(ctx *Context) {}
+	h.Get("/", mockHandler)
+	h.Get("/user/:id", mockHandler)
+	h.Get("/order/:id(^[0-9]+$)/detail", mockHandler)
+	h.Get("/static/*file", mockHandler)
+	h.Name("home", "/")
+	h.Name("user.detail", "/user/:id")
+	h.Name("order.detail", "/order/:id(^[0-9]+$)/detail")
+	h.Name("static", "/static/*file")
+	admin := h.Group("/admin")
+	admin.Get("/user/:name", mockHandler)
+	admin.Name("admin.user", "/user/:name")
+
+	testCases := []struct {
+		name    string
+		route   string
+		params  map[string]string
+		query   url.Values
+		wantURL string
+		wantErr string
+	}{
+		{
+			name:    "root",
+			route:   "home",
+			wantURL: "/",
+		},
+		{
+			name:    "param",
+			route:   "user.detail",
+			params:  map[string]string{"id": "12"},
+			query:   url.Values{"tab": {"info"}},
+			wantURL: "/user/12?tab=info",
+		},
+		{
+			name:    "escape",
+			route:   "user.detail",
+			params:  map[string]string{"id": "a b/c"},
+			wantURL: "/user/a%20b%2Fc",
+		},
+		{
+			name:    "reg",
+			route:   "order.detail",
+			params:  map[string]string{"id": "12"},
+			wantURL: "/order/12/detail",
+		},
+		{
+			name:    "reg not match",
+			route:   "order.detail",
+			params:  map[string]string{"id": "abc"},
+			wantErr: "web: 路由 /order/:id(^[0-9]+$)/detail 的路径参数 id 不满足正则 ^[0-9]+$",
+		},
+		{
+			name:    "any",
+			route:   "static",
+			params:  map[string]string{"file": "css/app.css"},
+			wantURL: "/static/css/app.css",
+		},
+		{
+			name:    "group",
+			route:   "admin.user",
+			params:  map[string]string{"name": "zhangsan"},
+			wantURL: "/admin/user/zhangsan",
+		},
+		{
+			name:    "missing param",
+			route:   "user.detail",
+			wantErr: "web: 路由 /user/:id 缺少路径参数 id",
+		},
+		{
+			name:    "unknown route",
+			route:   "abc",
+			wantErr: "web: 路由 abc 不存在",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := h.URLFor(tc.route, tc.params, tc.query)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantURL, res)
+		})
+	}
+
+	assert.Panicsf(t, func() {
+		h.Name("home", "/home")
+	}, "web: 路由名字冲突, 重复命名[home]")
+	assert.Panicsf(t, func() {
+		h.Name("bad", "/a//b")
+	}, "web: 不能有连续的 /")
+	assert.PanicsWithValue(t, "web: 路由 /order/:id 没有注册, 不能命名为 order", func() {
+		h.Name("order", "/order/:id")
+	})
+}
+
+func TestHTTPServer_URLForRemoved(t *testing.T) {
+	h := NewHTTPServer()
+	mockHandler := func(ctx *Context) {}
+	h.Get("/user/:id", mockHandler)
+	h.Post("/user/:id", mockHandler)
+	h.Name("user.detail", "/user/:id")
+
+	// 还有 POST, 名字仍然有效
+	require.True(t, h.RemoveRoute(http.MethodGet, "/user/:id"))
+	res, err := h.URLFor("user.detail", map[string]string{"id": "12"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/user/12", res)
+
+	require.True(t, h.RemoveRoute(http.MethodPost, "/user/:id"))
+	_, err = h.URLFor("user.detail", map[string]string{"id": "12"}, nil)
+	assert.EqualError(t, err, "web: 路由 user.detail 不存在")
+}
+
+func TestHTTPServer_TemplateFuncs(t *testing.T) {
+	h := NewHTTPServer(ServerWithGoTemplateEngine(func(t *template.Template) (*template.Template, error) {
+		return t.New("user").Parse(`<a href="{{ urlFor "user.detail" "id" .ID "tab" "info" }}">detail</a>`)
+	}))
+	h.Get("/user/:id", func(ctx *Context) {
+		_ = ctx.Render("user", map[string]string{"ID": ctx.PathParams["id"]})
+	})
+	h.Name("user.detail", "/user/:id")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/user/12", nil))
+	assert.Equal(t, `<a href="/user/12?tab=info">detail</a>`, resp.Body.String())
+
+	_, err := h.templateURLFor("user.detail", "id")
+	assert.EqualError(t, err, "web: urlFor 的参数必须是成对的 key value")
+
+	assert.Panics(t, func() {
+		NewHTTPServer(ServerWithGoTemplateEngine(func(t *template.Template) (*template.Template, error) {
+			return t.Parse(`{{ notExist }}`)
+		}))
+	})
+}
+
+func TestHTTPServer_URLForConcurrent(t *testing.T) {
+	h := NewHTTPServer(ServerWithSafeRouter())
+	mockHandler := func(ctx *Context) {}
+	for i := 0; i < 10; i++ {
+		path := "/user/" + strconv.Itoa(i) + "/:id"
+		h.Get(path, mockHandler)
+		h.Name("user."+strconv.Itoa(i), path)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		i := i
+		go func() {
+			defer wg.Done()
+			h.RemoveRoute(http.MethodGet, "/user/"+strconv.Itoa(i)+"/:id")
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = h.URLFor("user."+strconv.Itoa(i), map[string]string{"id": "12"}, nil)
+			_, _ = h.templateURLFor("user."+strconv.Itoa(i), "id", "12")
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		_, err := h.URLFor("user."+strconv.Itoa(i), map[string]string{"id": "12"}, nil)
+		assert.Error(t, err)
+	}
+}
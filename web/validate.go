@@ -0,0 +1,341 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidateFunc 校验规则
+// val 是字段的值, 指针会被解引用; param 是规则的参数, 例如 min=1 里面的 1
+// 返回 false 代表校验失败
+type ValidateFunc func(val any, param string) bool
+
+// FieldError 某个字段校验失败
+type FieldError struct {
+	// 字段路径, 优先使用 json 标签, 例如 users[0].name
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f *FieldError) Error() string {
+	return f.Message
+}
+
+// ValidationErrors 一次校验会把所有字段都校验完, 返回全部的错误
+type ValidationErrors []*FieldError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, fe := range v {
+		msgs = append(msgs, fe.Message)
+	}
+	return "web: 参数校验失败: " + strings.Join(msgs, "; ")
+}
+
+// Validate 用 validate 标签校验结构体, 例如
+//
+//	type Req struct {
+//		Name  string   `json:"name" validate:"required,min=1,max=64"`
+//		Email string   `json:"email" validate:"email"`
+//		Role  string   `json:"role" validate:"oneof=admin user"`
+//		Items []Item   `json:"items" validate:"required"`
+//	}
+//
+// 没有 required 的字段, 值为零值的时候跳过别的规则
+// 结构体字段和结构体切片会递归校验
+// 校验失败返回 ValidationErrors
+func Validate(val any) error {
+	return defaultValidator.validate(val)
+}
+
+// RegisterValidation 注册自定义的校验规则, 同名规则会被覆盖
+// 要在使用之前注册, 一般放在 init 或者 main 里面
+func RegisterValidation(name string, fn ValidateFunc) {
+	defaultValidator.rules.Store(name, fn)
+}
+
+// Validate 校验 val, 只返回 error, 不会生成响应
+// 在 WrapErr 里面直接返回这个 error, 或者调用 ctx.Error(err), 交给 ErrorHandler 生成响应
+// 默认的 ErrorHandler 返回 400, body 里面是每个字段的错误, 例如 JSON 是
+//
+//	{"code":400,"message":"参数校验失败","errors":[{"field":"name","rule":"required","message":"name 不能为空"}]}
+func (c *Context) Validate(val any) error {
+	return Validate(val)
+}
+
+// BindAndValidate 先 Bind 再 Validate, 和 Validate 一样只返回 error
+// 交给默认的 ErrorHandler 的话, 绑定失败和校验失败是 400, body 太大是 413
+func (c *Context) BindAndValidate(val any) error {
+	if err := c.Bind(val); err != nil {
+		return err
+	}
+	return c.Validate(val)
+}
+
+var (
+	errValidatePointerOnly = errors.New("web: 只支持结构体或者指向结构体的指针")
+	emailRegexp            = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	timeType               = reflect.TypeOf(time.Time{})
+)
+
+var defaultValidator = newValidator()
+
+type validator struct {
+	// 规则名字到 ValidateFunc 的映射
+	rules sync.Map
+	// 缓存结构体解析出来的元数据, reflect.Type => *validateModel
+	models sync.Map
+}
+
+func newValidator() *validator {
+	v := &validator{}
+	v.rules.Store("min", ValidateFunc(validateMin))
+	v.rules.Store("max", ValidateFunc(validateMax))
+	v.rules.Store("email", ValidateFunc(validateEmail))
+	v.rules.Store("oneof", ValidateFunc(validateOneOf))
+	return v
+}
+
+type validateModel struct {
+	fields []*validateField
+}
+
+type validateField struct {
+	index []int
+	// 错误信息里面的字段名
+	name     string
+	required bool
+	rules    []validateRule
+	embedded bool
+}
+
+type validateRule struct {
+	name  string
+	param string
+}
+
+func (v *validator) validate(val any) error {
+	rv := reflect.ValueOf(val)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return errValidatePointerOnly
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errValidatePointerOnly
+	}
+	var ves ValidationErrors
+	if err := v.validateStruct(rv, "", &ves); err != nil {
+		return err
+	}
+	if len(ves) > 0 {
+		return ves
+	}
+	return nil
+}
+
+func (v *validator) validateStruct(rv reflect.Value, prefix string, ves *ValidationErrors) error {
+	m, err := v.get(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, fd := range m.fields {
+		fv := rv.FieldByIndex(fd.index)
+		// 嵌入的结构体, 字段名不加前缀
+		if fd.embedded {
+			for fv.Kind() == reflect.Pointer && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err = v.validateStruct(fv, prefix, ves); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err = v.validateField(fd, fv, prefix+fd.name, ves); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateField(fd *validateField, fv reflect.Value, name string, ves *ValidationErrors) error {
+	if fv.IsZero() {
+		if fd.required {
+			*ves = append(*ves, &FieldError{Field: name, Rule: "required", Message: name + " 不能为空"})
+		}
+		// 结构体是零值, 里面的字段也要校验, 例如里面有 required 的字段
+		if fv.Kind() == reflect.Struct {
+			return v.validateNested(fv, name, ves)
+		}
+		return nil
+	}
+	for fv.Kind() == reflect.Pointer {
+		fv = fv.Elem()
+	}
+	for _, r := range fd.rules {
+		fn, ok := v.rules.Load(r.name)
+		if !ok {
+			return fmt.Errorf("web: 未知的校验规则 %s", r.name)
+		}
+		if !fn.(ValidateFunc)(fv.Interface(), r.param) {
+			*ves = append(*ves, &FieldError{Field: name, Rule: r.name, Param: r.param, Message: ruleMessage(name, r)})
+		}
+	}
+	return v.validateNested(fv, name, ves)
+}
+
+// validateNested 递归校验结构体字段, 以及元素是结构体的切片
+func (v *validator) validateNested(fv reflect.Value, name string, ves *ValidationErrors) error {
+	switch fv.Kind() {
+	case reflect.Struct:
+		if fv.Type() == timeType {
+			return nil
+		}
+		return v.validateStruct(fv, name+".", ves)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			elem := fv.Index(i)
+			for elem.Kind() == reflect.Pointer && !elem.IsNil() {
+				elem = elem.Elem()
+			}
+			if elem.Kind() != reflect.Struct || elem.Type() == timeType {
+				continue
+			}
+			if err := v.validateStruct(elem, fmt.Sprintf("%s[%d].", name, i), ves); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) get(typ reflect.Type) (*validateModel, error) {
+	m, ok := v.models.Load(typ)
+	if ok {
+		return m.(*validateModel), nil
+	}
+	res, err := v.parse(typ)
+	if err != nil {
+		return nil, err
+	}
+	v.models.Store(typ, res)
+	return res, nil
+}
+
+func (v *validator) parse(typ reflect.Type) (*validateModel, error) {
+	res := &validateModel{}
+	for i := 0; i < typ.NumField(); i++ {
+		fd := typ.Field(i)
+		tag := fd.Tag.Get("validate")
+		if tag == "-" || !fd.IsExported() && !fd.Anonymous {
+			continue
+		}
+		vf := &validateField{
+			index:    []int{i},
+			name:     fieldName(fd),
+			embedded: fd.Anonymous,
+		}
+		if tag != "" {
+			for _, r := range strings.Split(tag, ",") {
+				name, param, _ := strings.Cut(strings.TrimSpace(r), "=")
+				if name == "required" {
+					vf.required = true
+					continue
+				}
+				if _, ok := v.rules.Load(name); !ok {
+					return nil, fmt.Errorf("web: 字段 %s 使用了未知的校验规则 %s", fd.Name, name)
+				}
+				vf.rules = append(vf.rules, validateRule{name: name, param: param})
+			}
+		}
+		res.fields = append(res.fields, vf)
+	}
+	return res, nil
+}
+
+// fieldName 优先使用 json 标签里面的名字, 这样错误信息和请求里面的字段能对上
+func fieldName(fd reflect.StructField) string {
+	name, _, _ := strings.Cut(fd.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return fd.Name
+	}
+	return name
+}
+
+func ruleMessage(name string, r validateRule) string {
+	switch r.name {
+	case "min":
+		return fmt.Sprintf("%s 不能小于 %s", name, r.param)
+	case "max":
+		return fmt.Sprintf("%s 不能大于 %s", name, r.param)
+	case "email":
+		return fmt.Sprintf("%s 不是合法的邮箱", name)
+	case "oneof":
+		return fmt.Sprintf("%s 必须是 [%s] 其中之一", name, r.param)
+	}
+	return fmt.Sprintf("%s 不满足校验规则 %s", name, r.name)
+}
+
+// size 字符串是字符数, 切片和 map 是长度, 数字是值本身
+func size(val any) (float64, bool) {
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(rv.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(rv.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+func validateMin(val any, param string) bool {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return false
+	}
+	s, ok := size(val)
+	return ok && s >= limit
+}
+
+func validateMax(val any, param string) bool {
+	limit, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return false
+	}
+	s, ok := size(val)
+	return ok && s <= limit
+}
+
+func validateEmail(val any, _ string) bool {
+	str, ok := val.(string)
+	return ok && emailRegexp.MatchString(str)
+}
+
+// validateOneOf 参数用空格分隔, 例如 oneof=admin user
+func validateOneOf(val any, param string) bool {
+	str := fmt.Sprint(val)
+	for _, opt := range strings.Fields(param) {
+		if str == opt {
+			return true
+		}
+	}
+	return false
+}
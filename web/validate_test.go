@@ -0,0 +1,193 @@
+package web
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type validateAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type validateItem struct {
+	Name  string `json:"name" validate:"required,max=4"`
+	Count int    `json:"count" validate:"min=1"`
+}
+
+type validateUser struct {
+	Name    string          `json:"name" validate:"required,min=1,max=8"`
+	Email   string          `json:"email" validate:"email"`
+	Role    string          `json:"role" validate:"oneof=admin user"`
+	Age     *int            `json:"age" validate:"min=18"`
+	Address validateAddress `json:"address"`
+	Items   []*validateItem `json:"items" validate:"required,max=2"`
+	Phone   string          `json:"phone" validate:"phone"`
+	Ignored string          `validate:"-"`
+}
+
+func TestValidate(t *testing.T) {
+	RegisterValidation("phone", func(val any, param string) bool {
+		str, ok := val.(string)
+		return ok && len(str) == 11
+	})
+
+	age := 10
+	testCases := []struct {
+		name    string
+		val     any
+		wantErr error
+	}{
+		{
+			name: "valid",
+			val: &validateUser{
+				Name:    "zhangsan",
+				Email:   "zhangsan@example.com",
+				Role:    "admin",
+				Address: validateAddress{City: "shanghai"},
+				Items:   []*validateItem{{Name: "a", Count: 1}},
+				Phone:   "12345678901",
+			},
+		},
+		{
+			name: "invalid",
+			val: validateUser{
+				Name:  "zhangsan-lisi",
+				Email: "zhangsan",
+				Role:  "root",
+				Age:   &age,
+				Items: []*validateItem{{Name: "abcde", Count: 1}, {Name: "b", Count: -1}, {Name: "c", Count: 1}},
+				Phone: "123",
+			},
+			wantErr: ValidationErrors{
+				{Field: "name", Rule: "max", Param: "8", Message: "name 不能大于 8"},
+				{Field: "email", Rule: "email", Message: "email 不是合法的邮箱"},
+				{Field: "role", Rule: "oneof", Param: "admin user", Message: "role 必须是 [admin user] 其中之一"},
+				{Field: "age", Rule: "min", Param: "18", Message: "age 不能小于 18"},
+				{Field: "address.city", Rule: "required", Message: "address.city 不能为空"},
+				{Field: "items", Rule: "max", Param: "2", Message: "items 不能大于 2"},
+				{Field: "items[0].name", Rule: "max", Param: "4", Message: "items[0].name 不能大于 4"},
+				{Field: "items[1].count", Rule: "min", Param: "1", Message: "items[1].count 不能小于 1"},
+				{Field: "phone", Rule: "phone", Message: "phone 不满足校验规则 phone"},
+			},
+		},
+		{
+			name: "required",
+			val:  &validateUser{Address: validateAddress{City: "shanghai"}},
+			wantErr: ValidationErrors{
+				{Field: "name", Rule: "required", Message: "name 不能为空"},
+				{Field: "items", Rule: "required", Message: "items 不能为空"},
+			},
+		},
+		{
+			name:    "not struct",
+			val:     "abc",
+			wantErr: errValidatePointerOnly,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.val)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+
+	err := Validate(&struct {
+		Name string `validate:"unknown"`
+	}{})
+	assert.EqualError(t, err, "web: 字段 Name 使用了未知的校验规则 unknown")
+}
+
+func TestContext_BindAndValidate(t *testing.T) {
+	type createReq struct {
+		ID   int64  `path:"id" json:"id"`
+		Name string `json:"name" validate:"required"`
+	}
+
+	// ErrorHandler 每次失败只会执行一次
+	var handled int
+	h := NewHTTPServer(ServerWithErrorHandler(func(ctx *Context, err error) {
+		handled++
+		defaultErrorHandler(ctx, err, "")
+	}))
+	h.Post("/user/:id", func(ctx *Context) {
+		var req createReq
+		if err := ctx.BindAndValidate(&req); err != nil {
+			ctx.Error(err)
+			return
+		}
+		_ = ctx.RespJSONOK(req)
+	})
+	// 返回的 error 交给 ErrorHandler, 响应是一样的
+	h.Post("/wrap/:id", WrapErr(func(ctx *Context) error {
+		var req createReq
+		if err := ctx.BindAndValidate(&req); err != nil {
+			return err
+		}
+		return ctx.RespJSONOK(req)
+	}))
+
+	testCases := []struct {
+		name        string
+		path        string
+		body        string
+		wantCode    int
+		wantBody    string
+		wantHandled int
+	}{
+		{
+			name:     "ok",
+			path:     "/user/12",
+			body:     `{"name":"zhangsan"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":12,"name":"zhangsan"}`,
+		},
+		{
+			name:        "validate failed",
+			path:        "/user/12",
+			body:        `{}`,
+			wantCode:    http.StatusBadRequest,
+			wantBody:    `{"code":400,"message":"参数校验失败","errors":[{"field":"name","rule":"required","message":"name 不能为空"}]}`,
+			wantHandled: 1,
+		},
+		{
+			name:        "bind failed",
+			path:        "/user/abc",
+			body:        `{"name":"zhangsan"}`,
+			wantCode:    http.StatusBadRequest,
+			wantBody:    `{"code":400,"message":"参数格式错误, 来源 path[id]"}`,
+			wantHandled: 1,
+		},
+		{
+			name:        "wrap validate failed",
+			path:        "/wrap/12",
+			body:        `{}`,
+			wantCode:    http.StatusBadRequest,
+			wantBody:    `{"code":400,"message":"参数校验失败","errors":[{"field":"name","rule":"required","message":"name 不能为空"}]}`,
+			wantHandled: 1,
+		},
+		{
+			name:        "wrap bind failed",
+			path:        "/wrap/abc",
+			body:        `{"name":"zhangsan"}`,
+			wantCode:    http.StatusBadRequest,
+			wantBody:    `{"code":400,"message":"参数格式错误, 来源 path[id]"}`,
+			wantHandled: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			handled = 0
+			h.ServeHTTP(resp, req)
+			require.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBody, resp.Body.String())
+			assert.Equal(t, tc.wantHandled, handled)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package web
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 消息类型, 和 RFC 6455 里面的 opcode 一致
+const (
+	TextMessage   = websocket.TextMessage
+	BinaryMessage = websocket.BinaryMessage
+)
+
+// 常用的关闭码, 更多的参考 RFC 6455 7.4.1
+const (
+	CloseNormalClosure     = websocket.CloseNormalClosure
+	CloseGoingAway         = websocket.CloseGoingAway
+	CloseProtocolError     = websocket.CloseProtocolError
+	CloseUnsupportedData   = websocket.CloseUnsupportedData
+	CloseMessageTooBig     = websocket.CloseMessageTooBig
+	ClosePolicyViolation   = websocket.ClosePolicyViolation
+	CloseInternalServerErr = websocket.CloseInternalServerErr
+)
+
+// CloseError 对端发过来的关闭帧, 可以用 errors.As 拿到关闭码
+type CloseError = websocket.CloseError
+
+// WebSocketHandler 处理 WebSocket 连接
+// 返回之后连接会被关闭, 所以读写都要在返回之前完成
+type WebSocketHandler func(ctx *Context, conn *WebSocketConn)
+
+// WebSocket 注册 WebSocket 路由, 使用默认配置的 WebSocketUpgrader
+// 路由上的 middleware 会在升级之前执行, 例如鉴权失败的时候直接返回, 不会升级
+//
+//	h.WebSocket("/ws/:room", func(ctx *Context, conn *WebSocketConn) {
+//		room := ctx.PathParams["room"]
+//		for {
+//			typ, data, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			_ = conn.WriteMessage(typ, data)
+//		}
+//	}, authMdl)
+func (h *HTTPServer) WebSocket(path string, handler WebSocketHandler, mdls ...Middleware) {
+	h.addNamedRoute(http.MethodGet, path, funcName(handler), WebSocketUpgrader{}.Handle(handler), mdls...)
+}
+
+// WebSocket 在分组下注册 WebSocket 路由
+func (g *RouteGroup) WebSocket(path string, handler WebSocketHandler, mdls ...Middleware) {
+	g.server.addNamedRoute(http.MethodGet, g.fullPath(path), funcName(handler), WebSocketUpgrader{}.Handle(handler), g.joinMiddlewares(mdls)...)
+}
+
+// WebSocketUpgrader 负责握手和连接的配置, 零值就可以使用
+// 需要修改配置的时候, 和 FileUploader 一样用 Handle 拿到 HandleFunc 再注册
+//
+//	u := WebSocketUpgrader{MaxMessageSize: 1 << 20, PingInterval: 30 * time.Second}
+//	h.Get("/ws/:room", u.Handle(handler), authMdl)
+type WebSocketUpgrader struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+	// 单个消息的最大字节数, 超过了对端会收到 1009 关闭帧, 0 代表不限制
+	MaxMessageSize int64
+	// 多久没有收到消息(包括 pong)就认为连接已经断开, 0 代表不限制
+	// 开启了 PingInterval 的时候, 应该比 PingInterval 大
+	ReadTimeout time.Duration
+	// 每次写的超时时间, 默认 10 秒
+	WriteTimeout time.Duration
+	// 定时发送 ping, 0 代表不发送
+	PingInterval time.Duration
+	// 支持的子协议, 按照优先级排列
+	Subprotocols []string
+	// 校验 Origin, 默认要求 Origin 和 Host 一致
+	CheckOrigin func(r *http.Request) bool
+}
+
+func (u WebSocketUpgrader) Handle(handler WebSocketHandler) HandleFunc {
+	if u.WriteTimeout == 0 {
+		u.WriteTimeout = 10 * time.Second
+	}
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:  u.ReadBufferSize,
+		WriteBufferSize: u.WriteBufferSize,
+		Subprotocols:    u.Subprotocols,
+		CheckOrigin:     u.CheckOrigin,
+	}
+	return func(ctx *Context) {
+		if !websocket.IsWebSocketUpgrade(ctx.Req) {
+			ctx.RespStatusCode = http.StatusBadRequest
+			ctx.RespData = []byte("NOT WEBSOCKET")
+			return
+		}
+		// 握手失败的时候不直接写响应, 而是走 RespData 和 RespStatusCode, 这样 middleware 能够看到
+		// 响应码用 gorilla 给的, 例如 Origin 不对是 403
+		// Error 只能拿到 http.ResponseWriter, 所以每个请求复制一份 upgrader 来记录响应码
+		status := http.StatusBadRequest
+		up := *upgrader
+		up.Error = func(w http.ResponseWriter, r *http.Request, s int, reason error) {
+			status = s
+		}
+		// RespHeader 里面的头部, 例如 Set-Cookie, 跟着握手的响应一起发出去
+		raw, err := up.Upgrade(ctx.Resp, ctx.Req, ctx.RespHeader)
+		if err != nil {
+			ctx.RespStatusCode = status
+			ctx.RespData = []byte(http.StatusText(status))
+			return
+		}
+		ctx.RespStatusCode = http.StatusSwitchingProtocols
+		conn := newWebSocketConn(raw, u)
+		defer conn.close()
+		handler(ctx, conn)
+	}
+}
+
+// WebSocketConn 对 websocket.Conn 的封装
+// 写是并发安全的, 读只能在一个 goroutine 里面
+type WebSocketConn struct {
+	conn *websocket.Conn
+	cfg  WebSocketUpgrader
+
+	writeMu   sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	ping      sync.WaitGroup
+}
+
+func newWebSocketConn(conn *websocket.Conn, cfg WebSocketUpgrader) *WebSocketConn {
+	res := &WebSocketConn{
+		conn: conn,
+		cfg:  cfg,
+		done: make(chan struct{}),
+	}
+	if cfg.MaxMessageSize > 0 {
+		conn.SetReadLimit(cfg.MaxMessageSize)
+	}
+	if cfg.ReadTimeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
+		// 收到 pong 说明连接还活着
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(cfg.ReadTimeout))
+		})
+	}
+	if cfg.PingInterval > 0 {
+		res.ping.Add(1)
+		go res.pingLoop()
+	}
+	return res
+}
+
+// Subprotocol 握手协商出来的子协议
+func (c *WebSocketConn) Subprotocol() string {
+	return c.conn.Subprotocol()
+}
+
+// ReadMessage 读取一个完整的消息, 会自动处理 ping, pong 和 close 帧
+// 对端关闭的时候返回 *CloseError
+func (c *WebSocketConn) ReadMessage() (int, []byte, error) {
+	typ, data, err := c.conn.ReadMessage()
+	if err == nil && c.cfg.ReadTimeout > 0 {
+		err = c.conn.SetReadDeadline(time.Now().Add(c.cfg.ReadTimeout))
+	}
+	return typ, data, err
+}
+
+// WriteMessage 写一个消息, typ 是 TextMessage 或者 BinaryMessage
+func (c *WebSocketConn) WriteMessage(typ int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout)); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(typ, data)
+}
+
+func (c *WebSocketConn) WriteText(data string) error {
+	return c.WriteMessage(TextMessage, []byte(data))
+}
+
+func (c *WebSocketConn) WriteBinary(data []byte) error {
+	return c.WriteMessage(BinaryMessage, data)
+}
+
+// Ping 发送 ping 帧, 对端会自动回复 pong
+func (c *WebSocketConn) Ping(data []byte) error {
+	return c.conn.WriteControl(websocket.PingMessage, data, time.Now().Add(c.cfg.WriteTimeout))
+}
+
+// Close 发送关闭帧, 对端收到之后会回复关闭帧, 然后断开连接
+// handler 返回之后框架会关闭底层的连接
+func (c *WebSocketConn) Close(code int, reason string) error {
+	msg := websocket.FormatCloseMessage(code, reason)
+	return c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.cfg.WriteTimeout))
+}
+
+func (c *WebSocketConn) pingLoop() {
+	defer c.ping.Done()
+	ticker := time.NewTicker(c.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if c.Ping(nil) != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *WebSocketConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	c.ping.Wait()
+	_ = c.conn.Close()
+}
@@ -0,0 +1,194 @@
+package web
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_WebSocket(t *testing.T) {
+	server := NewHTTPServer()
+	status := make(chan int, 1)
+	auth := func(next HandleFunc) HandleFunc {
+		return func(ctx *Context) {
+			if ctx.Req.URL.Query().Get("token") != "123" {
+				ctx.RespStatusCode = http.StatusUnauthorized
+				return
+			}
+			next(ctx)
+			status <- ctx.RespStatusCode
+		}
+	}
+	server.WebSocket("/ws/:room", func(ctx *Context, conn *WebSocketConn) {
+		for {
+			typ, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "bye" {
+				_ = conn.Close(CloseNormalClosure, "bye")
+				return
+			}
+			if typ == TextMessage {
+				data = []byte(ctx.PathParams["room"] + ":" + string(data))
+			}
+			require.NoError(t, conn.WriteMessage(typ, data))
+		}
+	}, auth)
+	httpSrv := httptest.NewServer(server)
+	defer httpSrv.Close()
+	url := "ws" + strings.TrimPrefix(httpSrv.URL, "http")
+
+	// 鉴权失败, 不会升级
+	_, resp, err := websocket.DefaultDialer.Dial(url+"/ws/go", nil)
+	require.Equal(t, websocket.ErrBadHandshake, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url+"/ws/go?token=123", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	typ, data, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, typ)
+	assert.Equal(t, "go:hello", string(data))
+
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3}))
+	typ, data, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, websocket.BinaryMessage, typ)
+	assert.Equal(t, []byte{1, 2, 3}, data)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("bye")))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseNormalClosure, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Text)
+	_ = conn.Close()
+
+	// middleware 能看到 101
+	select {
+	case code := <-status:
+		assert.Equal(t, http.StatusSwitchingProtocols, code)
+	case <-time.After(time.Second):
+		t.Fatal("middleware 没有执行完")
+	}
+}
+
+func TestWebSocketUpgrader_NotWebSocket(t *testing.T) {
+	server := NewHTTPServer()
+	server.WebSocket("/ws", func(ctx *Context, conn *WebSocketConn) {
+		t.Fatal("不应该执行")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "NOT WEBSOCKET", resp.Body.String())
+}
+
+func TestWebSocketUpgrader_HandshakeFailed(t *testing.T) {
+	server := NewHTTPServer()
+	server.WebSocket("/ws", mockWebSocketHandler)
+	testCases := []struct {
+		name     string
+		header   http.Header
+		wantCode int
+	}{
+		{
+			// Origin 和 Host 不一致
+			name: "bad origin",
+			header: http.Header{
+				"Connection":            []string{"Upgrade"},
+				"Upgrade":               []string{"websocket"},
+				"Sec-Websocket-Version": []string{"13"},
+				"Sec-Websocket-Key":     []string{"dGhlIHNhbXBsZSBub25jZQ=="},
+				"Origin":                []string{"http://evil.com"},
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "bad version",
+			header: http.Header{
+				"Connection":            []string{"Upgrade"},
+				"Upgrade":               []string{"websocket"},
+				"Sec-Websocket-Version": []string{"12"},
+				"Sec-Websocket-Key":     []string{"dGhlIHNhbXBsZSBub25jZQ=="},
+			},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			req.Header = tc.header
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, http.StatusText(tc.wantCode), resp.Body.String())
+		})
+	}
+
+	// Routes 里面显示的是原始的 handler
+	assert.Equal(t, "my-frame/web.mockWebSocketHandler", server.Routes()[0].Handler)
+}
+
+func mockWebSocketHandler(ctx *Context, conn *WebSocketConn) {}
+
+func TestWebSocketUpgrader_Config(t *testing.T) {
+	server := NewHTTPServer()
+	u := WebSocketUpgrader{
+		MaxMessageSize: 4,
+		PingInterval:   10 * time.Millisecond,
+		ReadTimeout:    time.Second,
+		Subprotocols:   []string{"chat"},
+	}
+	readErr := make(chan error, 1)
+	server.Get("/ws", u.Handle(func(ctx *Context, conn *WebSocketConn) {
+		assert.Equal(t, "chat", conn.Subprotocol())
+		_, _, err := conn.ReadMessage()
+		readErr <- err
+	}))
+	httpSrv := httptest.NewServer(server)
+	defer httpSrv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"chat"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(httpSrv.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	pings := make(chan struct{}, 10)
+	conn.SetPingHandler(func(appData string) error {
+		pings <- struct{}{}
+		return conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-pings:
+	case <-time.After(time.Second):
+		t.Fatal("没有收到 ping")
+	}
+
+	// 超过了 MaxMessageSize
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+	select {
+	case err = <-readErr:
+		assert.Equal(t, websocket.ErrReadLimit, err)
+	case <-time.After(time.Second):
+		t.Fatal("没有触发读取限制")
+	}
+}